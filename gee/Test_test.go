@@ -1,10 +1,18 @@
 package gee
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func newTestRouter() *router {
@@ -41,23 +49,30 @@ func TestGetRoute(t *testing.T) {
 		t.Fatal("should match /hello/:name")
 	}
 
-	if ps["name"] != "geektutu" {
+	if ps.ByName("name") != "geektutu" {
 		t.Fatal("name should be equal to 'geektutu'")
 	}
 
-	fmt.Printf("matched path: %s, params['name']: %s\n", n.pattern, ps["name"])
+	fmt.Printf("matched path: %s, params['name']: %s\n", n.pattern, ps.ByName("name"))
 
 }
 
+func performRequest(r http.Handler, method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
 func TestDemo(t *testing.T) {
 	r := New()
 	r.GET("/index", func(c *Context) {
-		c.HTML(http.StatusOK, "<h1>Index Page</h1>")
+		c.HTML(http.StatusOK, "<h1>Index Page</h1>", nil)
 	})
 	v1 := r.Group("/v1")
 	{
 		v1.GET("/", func(c *Context) {
-			c.HTML(http.StatusOK, "<h1>Hello Gee</h1>")
+			c.HTML(http.StatusOK, "<h1>Hello Gee</h1>", nil)
 		})
 
 		v1.GET("/hello", func(c *Context) {
@@ -79,5 +94,1524 @@ func TestDemo(t *testing.T) {
 		})
 
 	}
-	t.Error(r.Run(":9090"))
+
+	w := performRequest(r, "GET", "/v1/hello?name=geektutu")
+	if w.Body.String() != "hello geektutu, you're at /v1/hello\n" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	w = performRequest(r, "GET", "/v2/hello/geektutu")
+	if w.Body.String() != "hello geektutu, you're at /v2/hello/geektutu\n" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	w = performRequest(r, "POST", "/v2/login")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestMethods(t *testing.T) {
+	r := New()
+	echo := func(c *Context) {
+		c.String(http.StatusOK, "%s", c.Method)
+	}
+	r.PUT("/put", echo)
+	r.PATCH("/patch", echo)
+	r.DELETE("/delete", echo)
+	r.Handle("PROPFIND", "/dav", echo)
+	r.Any("/any", echo)
+	r.Match([]string{"GET", "MKCOL"}, "/match", echo)
+
+	cases := []struct{ method, path string }{
+		{"PUT", "/put"}, {"PATCH", "/patch"}, {"DELETE", "/delete"}, {"PROPFIND", "/dav"},
+		{"GET", "/any"}, {"TRACE", "/any"}, {"CONNECT", "/any"}, {"MKCOL", "/match"},
+	}
+	for _, tc := range cases {
+		if w := performRequest(r, tc.method, tc.path); w.Code != http.StatusOK || w.Body.String() != tc.method {
+			t.Fatalf("%s %s: got %d %q", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+	if w := performRequest(r, "POST", "/match"); w.Code != http.StatusNotFound {
+		t.Fatalf("POST /match should not be routed, got %d", w.Code)
+	}
+	for _, method := range []string{"", "GET POST", "BAD(METHOD)"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Handle(%q) should panic", method)
+				}
+			}()
+			r.Handle(method, "/invalid", echo)
+		}()
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	r := New()
+	handler := func(c *Context) {}
+	r.GET("/users/:id", handler)
+	r.PUT("/users/:id", handler)
+	r.DELETE("/users/:id", handler)
+
+	if w := performRequest(r, "POST", "/users/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("405 should be disabled by default, got %d", w.Code)
+	}
+
+	r.HandleMethodNotAllowed = true
+	w := performRequest(r, "POST", "/users/1")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "DELETE, GET, HEAD, OPTIONS, PUT" {
+		t.Fatalf("unexpected Allow header %q", allow)
+	}
+	if w := performRequest(r, "POST", "/posts/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown path should still be 404, got %d", w.Code)
+	}
+
+	var trace []string
+	r.Use(func(c *Context) {
+		trace = append(trace, "middleware")
+		c.Next()
+	})
+	r.NoMethod(func(c *Context) {
+		trace = append(trace, "noMethod")
+		c.JSON(http.StatusMethodNotAllowed, H{"allow": c.Writer.Header().Get("Allow")})
+	})
+	w = performRequest(r, "PATCH", "/users/1")
+	if w.Code != http.StatusMethodNotAllowed || !reflect.DeepEqual(trace, []string{"middleware", "noMethod"}) {
+		t.Fatalf("unexpected NoMethod result %d %v", w.Code, trace)
+	}
+}
+
+func TestAutoOptionsAndHead(t *testing.T) {
+	r := New()
+	r.GET("/users/:id", func(c *Context) {
+		c.SetHeader("X-User", c.Param("id"))
+		c.String(http.StatusOK, "user %s", c.Param("id"))
+	})
+	r.POST("/users/:id", func(c *Context) {})
+
+	w := performRequest(r, "OPTIONS", "/users/1")
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, HEAD, OPTIONS, POST" {
+		t.Fatalf("unexpected OPTIONS response %d %q", w.Code, w.Header().Get("Allow"))
+	}
+	if w := performRequest(r, "OPTIONS", "/posts/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("OPTIONS on unknown path should be 404, got %d", w.Code)
+	}
+
+	w = performRequest(r, "HEAD", "/users/42")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("unexpected HEAD response %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != "7" || w.Header().Get("X-User") != "42" {
+		t.Fatalf("HEAD should keep GET headers, got %v", w.Header())
+	}
+
+	// 流式输出的处理函数在 HEAD 请求下同样可以 Flush，也可以通过 Unwrap 拿到底层的 ResponseWriter
+	var unwrapped http.ResponseWriter
+	r.GET("/stream", func(c *Context) {
+		c.Status(http.StatusOK)
+		for i := 0; i < 3; i++ {
+			c.Writer.Write([]byte("chunk"))
+			c.Writer.(http.Flusher).Flush()
+		}
+		if u, ok := c.Writer.(interface{ Unwrap() http.ResponseWriter }); ok {
+			unwrapped = u.Unwrap()
+		}
+	})
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("HEAD", "/stream", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Length") != "15" {
+		t.Fatalf("unexpected streamed HEAD response %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+	if unwrapped != rec {
+		t.Fatalf("Unwrap should return the original writer, got %T", unwrapped)
+	}
+
+	r.HandleOPTIONS = false
+	r.HandleHEAD = false
+	if w := performRequest(r, "OPTIONS", "/users/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("auto OPTIONS should be disabled, got %d", w.Code)
+	}
+	if w := performRequest(r, "HEAD", "/users/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("auto HEAD should be disabled, got %d", w.Code)
+	}
+}
+
+func TestRoutePriority(t *testing.T) {
+	patterns := []string{"/src/*filepath", "/src/:file", "/src/main.go", "/src/:file/raw"}
+	cases := map[string]string{
+		"/src/main.go":      "/src/main.go",
+		"/src/util.go":      "/src/:file",
+		"/src/util.go/raw":  "/src/:file/raw",
+		"/src/main.go/raw":  "/src/:file/raw",
+		"/src/a/b/c":        "/src/*filepath",
+		"/src/main.go/blob": "/src/*filepath",
+	}
+	// 无论注册顺序如何，匹配结果都应当一致
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {2, 0, 3, 1}} {
+		r := NewRouter()
+		for _, i := range order {
+			r.addRoute("GET", patterns[i], nil)
+		}
+		for path, want := range cases {
+			n, _ := r.getRoute("GET", path)
+			if n == nil || n.pattern != want {
+				t.Fatalf("order %v: %s should match %s, got %v", order, path, want, n)
+			}
+		}
+	}
+}
+
+func TestRouteConflict(t *testing.T) {
+	conflicts := [][]string{
+		{"/hello/:name", "/hello/:id"},
+		{"/hello/:name/x", "/hello/:id/y"},
+		{"/static/*filepath", "/static/*path"},
+		{"/hello", "/hello"},
+		{"/hello/", "/hello//"},
+		{"/static/*filepath/edit"},
+		{"/users/:"},
+		{"/x/:id/y/:id"},
+		{"/x/:id/*id"},
+	}
+	for _, patterns := range conflicts {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%v should panic", patterns)
+				}
+			}()
+			r := NewRouter()
+			for _, pattern := range patterns {
+				r.addRoute("GET", pattern, nil)
+			}
+		}()
+	}
+}
+
+// benchRoutes 基准测试使用的路由表
+var benchRoutes = []string{
+	"/", "/about", "/contact", "/users", "/users/:id", "/users/:id/posts", "/users/:id/posts/:pid",
+	"/static/*filepath", "/api/v1/items", "/api/v1/items/:id", "/api/v1/orders/:id/lines/:line",
+}
+
+func newBenchRouter() *router {
+	r := NewRouter()
+	for _, pattern := range benchRoutes {
+		r.addRoute("GET", pattern, func(c *Context) {})
+	}
+	return r
+}
+
+func TestSearchZeroAlloc(t *testing.T) {
+	r := newBenchRouter()
+	ps := make(Params, 0, r.maxParams)
+	allocs := testing.AllocsPerRun(100, func() {
+		ps = ps[:0]
+		if r.search("GET", "/api/v1/orders/7/lines/3", &ps) == nil {
+			t.Fatal("route should match")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("search should not allocate, got %v allocs", allocs)
+	}
+	if ps.ByName("id") != "7" || ps.ByName("line") != "3" {
+		t.Fatalf("unexpected params %v", ps)
+	}
+}
+
+func benchmarkSearch(b *testing.B, path string) {
+	r := newBenchRouter()
+	ps := make(Params, 0, r.maxParams)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ps = ps[:0]
+		r.search("GET", path, &ps)
+	}
+}
+
+func BenchmarkSearchStatic(b *testing.B)   { benchmarkSearch(b, "/api/v1/items") }
+func BenchmarkSearchParam(b *testing.B)    { benchmarkSearch(b, "/users/42/posts/7") }
+func BenchmarkSearchCatchAll(b *testing.B) { benchmarkSearch(b, "/static/js/app/main.js") }
+
+// segmentNode 替换为 Radix 树之前按 / 分段的 Trie 树，只用于基准测试对比：
+// 查找时用 strings.Split 拆分路径，为候选子节点分配切片，路由参数保存在 map 中
+type segmentNode struct {
+	pattern  string
+	part     string
+	children []*segmentNode
+	isWild   bool
+}
+
+func splitSegments(pattern string) []string {
+	parts := make([]string, 0)
+	for _, item := range strings.Split(pattern, "/") {
+		if item != "" {
+			parts = append(parts, item)
+			if item[0] == '*' {
+				break
+			}
+		}
+	}
+	return parts
+}
+
+func (n *segmentNode) insert(pattern string, parts []string, height int) {
+	if len(parts) == height {
+		n.pattern = pattern
+		return
+	}
+	part := parts[height]
+	var child *segmentNode
+	for _, c := range n.children {
+		if c.part == part || c.isWild {
+			child = c
+			break
+		}
+	}
+	if child == nil {
+		child = &segmentNode{part: part, isWild: part[0] == ':' || part[0] == '*'}
+		n.children = append(n.children, child)
+	}
+	child.insert(pattern, parts, height+1)
+}
+
+func (n *segmentNode) search(parts []string, height int) *segmentNode {
+	if len(parts) == height || strings.HasPrefix(n.part, "*") {
+		if n.pattern == "" {
+			return nil
+		}
+		return n
+	}
+	part := parts[height]
+	children := make([]*segmentNode, 0)
+	for _, c := range n.children {
+		if c.part == part || c.isWild {
+			children = append(children, c)
+		}
+	}
+	for _, child := range children {
+		if result := child.search(parts, height+1); result != nil {
+			return result
+		}
+	}
+	return nil
+}
+
+// getRoute 按旧的方式查找路由并把参数收集到 map 中
+func (n *segmentNode) getRoute(path string) (*segmentNode, map[string]string) {
+	searchParts := splitSegments(path)
+	params := make(map[string]string)
+	found := n.search(searchParts, 0)
+	if found == nil {
+		return nil, nil
+	}
+	for index, part := range splitSegments(found.pattern) {
+		if part[0] == ':' {
+			params[part[1:]] = searchParts[index]
+		}
+		if part[0] == '*' && len(part) > 1 {
+			params[part[1:]] = strings.Join(searchParts[index:], "/")
+			break
+		}
+	}
+	return found, params
+}
+
+func benchmarkSegmentSearch(b *testing.B, path string) {
+	root := &segmentNode{}
+	for _, pattern := range benchRoutes {
+		root.insert(pattern, splitSegments(pattern), 0)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		root.getRoute(path)
+	}
+}
+
+// 旧实现的基准，与上面的 BenchmarkSearchXxx 使用相同的路由表和路径，便于对比
+func BenchmarkSegmentSearchStatic(b *testing.B) { benchmarkSegmentSearch(b, "/api/v1/items") }
+func BenchmarkSegmentSearchParam(b *testing.B)  { benchmarkSegmentSearch(b, "/users/42/posts/7") }
+func BenchmarkSegmentSearchCatchAll(b *testing.B) {
+	benchmarkSegmentSearch(b, "/static/js/app/main.js")
+}
+
+// discardWriter 丢弃全部输出的 http.ResponseWriter，避免 httptest.ResponseRecorder 的开销干扰基准测试
+type discardWriter struct{ header http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	r := New()
+	for _, pattern := range benchRoutes {
+		r.GET(pattern, func(c *Context) {})
+	}
+	req := httptest.NewRequest("GET", "/users/42/posts/7", nil)
+	w := &discardWriter{header: http.Header{}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.ServeHTTP(w, req)
+	}
+}
+
+func TestGroupMiddlewareChain(t *testing.T) {
+	r := New()
+	var trace []string
+	mark := func(name string) HandlerFunc {
+		return func(c *Context) {
+			trace = append(trace, name)
+			c.Next()
+		}
+	}
+	handler := func(c *Context) { trace = append(trace, "handler") }
+
+	v1 := r.Group("/v1")
+	v1.Use(mark("v1"))
+	admin := v1.Group("/admin")
+	admin.GET("/users", handler)
+	v1.GET("/ping", handler)
+	r.Group("/v10").GET("/ping", handler)
+
+	// 路由注册之后再添加的中间件同样生效
+	r.Use(mark("engine"))
+	admin.Use(mark("admin"))
+
+	cases := map[string][]string{
+		"/v1/admin/users": {"engine", "v1", "admin", "handler"},
+		"/v1/ping":        {"engine", "v1", "handler"},
+		"/v10/ping":       {"engine", "handler"},
+		"/missing":        {"engine"},
+	}
+	for path, want := range cases {
+		trace = nil
+		performRequest(r, "GET", path)
+		if !reflect.DeepEqual(trace, want) {
+			t.Fatalf("%s: expected %v, got %v", path, want, trace)
+		}
+	}
+}
+
+func TestRouteHandlersChain(t *testing.T) {
+	r := New()
+	var trace []string
+	mark := func(name string) HandlerFunc {
+		return func(c *Context) {
+			trace = append(trace, name)
+			c.Next()
+		}
+	}
+	auth := func(c *Context) {
+		if c.Query("token") == "" {
+			c.Fail(http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		trace = append(trace, "auth")
+	}
+
+	api := r.Group("/api", mark("api"))
+	api.GET("/public", func(c *Context) { trace = append(trace, "public") })
+	api.GET("/private", auth, func(c *Context) { trace = append(trace, "private") })
+
+	performRequest(r, "GET", "/api/private?token=x")
+	if !reflect.DeepEqual(trace, []string{"api", "auth", "private"}) {
+		t.Fatalf("unexpected chain %v", trace)
+	}
+	trace = nil
+	if w := performRequest(r, "GET", "/api/private"); w.Code != http.StatusUnauthorized || !reflect.DeepEqual(trace, []string{"api"}) {
+		t.Fatalf("route middleware should abort the chain, got %d %v", w.Code, trace)
+	}
+	trace = nil
+	performRequest(r, "GET", "/api/public")
+	if !reflect.DeepEqual(trace, []string{"api", "public"}) {
+		t.Fatalf("route middleware should not leak to other routes, got %v", trace)
+	}
+}
+
+func TestTrailingSlashAndFixedPath(t *testing.T) {
+	newEngine := func() *Engine {
+		r := New()
+		ok := func(c *Context) { c.String(http.StatusOK, "%s", c.Path) }
+		r.GET("/users/:id", ok)
+		r.POST("/users/:id", ok)
+		r.GET("/docs/", ok)
+		r.GET("/Articles/Latest", ok)
+		return r
+	}
+
+	// 默认：末尾的 / 和多余的 / 都可以匹配，但两种写法的路由可以同时注册
+	r := newEngine()
+	r.GET("/users/:id/", func(c *Context) { c.String(http.StatusOK, "slash") })
+	for path, code := range map[string]int{"/users/1": 200, "/docs": 200, "//users//1": 200, "/articles/latest": 404} {
+		if w := performRequest(r, "GET", path); w.Code != code {
+			t.Fatalf("lenient %s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+	if w := performRequest(r, "GET", "/users/1/"); w.Body.String() != "slash" {
+		t.Fatalf("/users/1/ should match its own route, got %q", w.Body.String())
+	}
+
+	// StrictSlash：末尾的 / 有意义
+	r = newEngine()
+	r.StrictSlash = true
+	for path, code := range map[string]int{"/users/1": 200, "/users/1/": 404, "/docs/": 200, "/docs": 404} {
+		if w := performRequest(r, "GET", path); w.Code != code {
+			t.Fatalf("strict %s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+
+	// 重定向到规范路由，GET 使用 301，其他方法使用 308 并保留查询参数
+	r.RedirectTrailingSlash = true
+	r.RedirectFixedPath = true
+	cases := []struct {
+		method, path string
+		code         int
+		location     string
+	}{
+		{"GET", "/users/1/", http.StatusMovedPermanently, "/users/1"},
+		{"GET", "/docs", http.StatusMovedPermanently, "/docs/"},
+		{"POST", "/users/1/?x=1", http.StatusPermanentRedirect, "/users/1?x=1"},
+		{"GET", "/USERS/Abc", http.StatusMovedPermanently, "/users/Abc"},
+		{"GET", "/articles//latest/", http.StatusMovedPermanently, "/Articles/Latest"},
+		{"GET", "/users/../docs/", http.StatusMovedPermanently, "/docs/"},
+	}
+	for _, tc := range cases {
+		w := performRequest(r, tc.method, tc.path)
+		if w.Code != tc.code || w.Header().Get("Location") != tc.location {
+			t.Fatalf("%s %s: expected %d %s, got %d %s", tc.method, tc.path, tc.code, tc.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestNamedRouteURL(t *testing.T) {
+	r := New()
+	handler := func(c *Context) {}
+	r.GET("/users/:id", handler).Name("user")
+	r.Group("/files").GET("/:owner/*filepath", handler).Name("file")
+	r.GET("/about", handler).Name("about")
+
+	cases := []struct {
+		name   string
+		params []interface{}
+		want   string
+	}{
+		{"user", []interface{}{"id", 42}, "/users/42"},
+		{"user", []interface{}{"id", "a/b c"}, "/users/a%2Fb%20c"},
+		{"file", []interface{}{"owner", "geektutu", "filepath", "docs/a b.txt"}, "/files/geektutu/docs/a%20b.txt"},
+		{"about", nil, "/about"},
+	}
+	for _, tc := range cases {
+		if got, err := r.URL(tc.name, tc.params...); err != nil || got != tc.want {
+			t.Fatalf("URL(%q, %v) = %q, %v; want %q", tc.name, tc.params, got, err, tc.want)
+		}
+	}
+
+	for _, params := range [][]interface{}{{}, {"name", "x"}, {"id"}, {1, 2}} {
+		if _, err := r.URL("user", params...); err == nil {
+			t.Fatalf("URL(user, %v) should fail", params)
+		}
+	}
+	if _, err := r.URL("missing"); err == nil {
+		t.Fatal("unknown route name should fail")
+	}
+
+	dir := t.TempDir()
+	tmpl := `<a href="{{url "user" "id" .ID}}">user</a>`
+	if err := os.WriteFile(filepath.Join(dir, "user.tmpl"), []byte(tmpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.LoadHTMLGlob(filepath.Join(dir, "*.tmpl"))
+	r.GET("/link", func(c *Context) {
+		c.HTML(http.StatusOK, "user.tmpl", H{"ID": 7})
+	})
+	if w := performRequest(r, "GET", "/link"); w.Body.String() != `<a href="/users/7">user</a>` {
+		t.Fatalf("unexpected template output %q", w.Body.String())
+	}
+}
+
+func TestParamConstraints(t *testing.T) {
+	r := NewRouter()
+	for _, pattern := range []string{
+		"/users/:id<int>", "/users/:name", "/users/:id<int>/posts",
+		"/posts/:slug<[a-z0-9-]+>", "/orders/:uuid<uuid>", "/files/:path<[^/]+\\.go>",
+	} {
+		r.addRoute("GET", pattern, nil)
+	}
+	cases := []struct{ path, pattern, key, value string }{
+		{"/users/42", "/users/:id<int>", "id", "42"},
+		{"/users/-7", "/users/:id<int>", "id", "-7"},
+		{"/users/geektutu", "/users/:name", "name", "geektutu"},
+		{"/users/99999999999999999999999", "/users/:name", "name", "99999999999999999999999"},
+		{"/users/-9223372036854775808", "/users/:id<int>", "id", "-9223372036854775808"},
+		{"/users/42/posts", "/users/:id<int>/posts", "id", "42"},
+		{"/posts/hello-gee-2", "/posts/:slug<[a-z0-9-]+>", "slug", "hello-gee-2"},
+		{"/orders/123e4567-e89b-12d3-A456-426614174000", "/orders/:uuid<uuid>", "uuid", "123e4567-e89b-12d3-A456-426614174000"},
+		{"/files/main.go", "/files/:path<[^/]+\\.go>", "path", "main.go"},
+	}
+	for _, tc := range cases {
+		n, ps := r.getRoute("GET", tc.path)
+		if n == nil || n.pattern != tc.pattern || ps.ByName(tc.key) != tc.value {
+			t.Fatalf("%s: expected %s with %s=%s, got %v %v", tc.path, tc.pattern, tc.key, tc.value, n, ps)
+		}
+	}
+	for _, path := range []string{"/users/x/posts", "/users/9223372036854775808/posts", "/posts/Hello", "/orders/123", "/files/main.c"} {
+		if n, _ := r.getRoute("GET", path); n != nil {
+			t.Fatalf("%s should not match, got %s", path, n.pattern)
+		}
+	}
+
+	for _, patterns := range [][]string{
+		{"/a/:id<int>", "/a/:num<int>"},
+		{"/a/:id<[0-9>"},
+		{"/a/:id<int>:name"},
+		{"/a/*path<int>"},
+		{"/a/:id<(>"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%v should panic", patterns)
+				}
+			}()
+			r := NewRouter()
+			for _, pattern := range patterns {
+				r.addRoute("GET", pattern, nil)
+			}
+		}()
+	}
+
+	e := New()
+	e.GET("/items/:id<int>", func(c *Context) {
+		id, err := c.ParamInt("id")
+		c.String(http.StatusOK, "%d %v", id+1, err)
+	}).Name("item")
+	if w := performRequest(e, "GET", "/items/41"); w.Body.String() != "42 <nil>" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	if _, err := e.URL("item", "id", "abc"); err == nil {
+		t.Fatal("URL should reject params that violate the constraint")
+	}
+}
+
+func TestMultiAndOptionalParams(t *testing.T) {
+	r := NewRouter()
+	for _, pattern := range []string{
+		"/files/:name.:ext", "/files/:name/raw", "/v:version/items", "/videos",
+		"/archive/:year<int>/:month<int>?", "/range/:from-:to", "/page/:a?/:b?",
+	} {
+		r.addRoute("GET", pattern, nil)
+	}
+	cases := []struct {
+		path, pattern string
+		params        Params
+	}{
+		{"/files/gee.tar.gz", "/files/:name.:ext", Params{{"name", "gee.tar"}, {"ext", "gz"}}},
+		{"/files/main.go", "/files/:name.:ext", Params{{"name", "main"}, {"ext", "go"}}},
+		{"/files/main/raw", "/files/:name/raw", Params{{"name", "main"}}},
+		{"/v2/items", "/v:version/items", Params{{"version", "2"}}},
+		{"/videos", "/videos", Params{}},
+		{"/vi/items", "/v:version/items", Params{{"version", "i"}}},
+		{"/archive/2023", "/archive/:year<int>/:month<int>?", Params{{"year", "2023"}}},
+		{"/archive/2023/03", "/archive/:year<int>/:month<int>?", Params{{"year", "2023"}, {"month", "03"}}},
+		{"/range/1-10", "/range/:from-:to", Params{{"from", "1"}, {"to", "10"}}},
+		{"/page", "/page/:a?/:b?", Params{}},
+		{"/page/x/y", "/page/:a?/:b?", Params{{"a", "x"}, {"b", "y"}}},
+	}
+	for _, tc := range cases {
+		n, ps := r.getRoute("GET", tc.path)
+		if n == nil || n.pattern != tc.pattern || !reflect.DeepEqual(ps, tc.params) {
+			t.Fatalf("%s: expected %s %v, got %v %v", tc.path, tc.pattern, tc.params, n, ps)
+		}
+	}
+	for _, path := range []string{"/files/main", "/archive/2023/mar", "/v/items"} {
+		if n, _ := r.getRoute("GET", path); n != nil {
+			t.Fatalf("%s should not match, got %s", path, n.pattern)
+		}
+	}
+
+	for _, patterns := range [][]string{
+		{"/a/:x:y"},
+		{"/a/:x?/b"},
+		{"/a/x:y?"},
+		{"/a/:x", "/a/:y?"},
+		{"/a/:b?", "/a"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%v should panic", patterns)
+				}
+			}()
+			r := NewRouter()
+			for _, pattern := range patterns {
+				r.addRoute("GET", pattern, nil)
+			}
+		}()
+	}
+
+	e := New()
+	e.GET("/archive/:year/:month?", func(c *Context) {}).Name("archive")
+	if got, _ := e.URL("archive", "year", 2023); got != "/archive/2023" {
+		t.Fatalf("unexpected URL %q", got)
+	}
+	if got, _ := e.URL("archive", "year", 2023, "month", 3); got != "/archive/2023/3" {
+		t.Fatalf("unexpected URL %q", got)
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	r := New()
+	r.GET("/users", func(c *Context) {
+		c.String(http.StatusOK, "default")
+	})
+	r.GET("/about", func(c *Context) {
+		c.String(http.StatusOK, "about")
+	})
+	tenant := r.Host(":tenant.example.com")
+	tenant.GET("/users/:id", func(c *Context) {
+		c.String(http.StatusOK, "%s/%s", c.Param("tenant"), c.Param("id"))
+	})
+	api := r.Host("api.:region<[a-z]+>.example.com").Group("/v1")
+	api.GET("/users", func(c *Context) {
+		c.String(http.StatusOK, "api-%s", c.Param("region"))
+	})
+
+	for _, tc := range []struct {
+		host, path, body string
+		code             int
+	}{
+		{"acme.example.com", "/users/42", "acme/42", http.StatusOK},
+		{"Acme.EXAMPLE.com:8080", "/users/7", "Acme/7", http.StatusOK},
+		{"api.eu.example.com", "/v1/users", "api-eu", http.StatusOK},
+		{"api.eu1.example.com", "/v1/users", "", http.StatusNotFound},
+		{"acme.example.com", "/about", "about", http.StatusOK},
+		{"example.com", "/users", "default", http.StatusOK},
+		{"a.b.example.com", "/users/42", "", http.StatusNotFound},
+		{"other.org", "/users/42", "", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Host = tc.host
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code || tc.body != "" && w.Body.String() != tc.body {
+			t.Fatalf("%s%s: expected %d %q, got %d %q", tc.host, tc.path, tc.code, tc.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func routesTestHandler(c *Context) {}
+
+func TestRoutes(t *testing.T) {
+	r := New()
+	r.Use(Logger())
+	r.GET("/", routesTestHandler).Name("home")
+	v1 := r.Group("/v1", Recovery())
+	v1.POST("/users", routesTestHandler)
+	v1.GET("/users", func(c *Context) {})
+	r.Host(":tenant.example.com").GET("/", routesTestHandler)
+
+	routes := r.Routes()
+	if len(routes) != 4 {
+		t.Fatalf("expected 4 routes, got %d", len(routes))
+	}
+	handler := "github.com/132982317/Gee/gee.routesTestHandler"
+	logger := "github.com/132982317/Gee/gee.Logger.func1"
+	recovery := "github.com/132982317/Gee/gee.Recovery.func1"
+	expected := []RouteInfo{
+		{Method: "GET", Path: "/", Name: "home", Handler: handler, Middlewares: []string{logger}},
+		{Method: "GET", Path: "/v1/users", Handler: "github.com/132982317/Gee/gee.TestRoutes.func1", Middlewares: []string{logger, recovery}, Group: "/v1"},
+		{Method: "POST", Path: "/v1/users", Handler: handler, Middlewares: []string{logger, recovery}, Group: "/v1"},
+		{Method: "GET", Path: "/", Host: ":tenant.example.com", Handler: handler, Middlewares: []string{logger}},
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("unexpected routes:\n%+v\nexpected:\n%+v", routes, expected)
+	}
+}
+
+func TestRemoveRoute(t *testing.T) {
+	r := New()
+	r.GET("/users/:id", func(c *Context) { c.String(http.StatusOK, "user") })
+	r.GET("/users/new", func(c *Context) { c.String(http.StatusOK, "new") }).Name("new-user")
+	r.GET("/files/:name?", func(c *Context) { c.String(http.StatusOK, "files") })
+	r.Host(":tenant.example.com").GET("/users/new", func(c *Context) { c.String(http.StatusOK, "tenant") })
+
+	if r.RemoveRoute("GET", "/missing") {
+		t.Fatal("removing a missing route should return false")
+	}
+	if !r.RemoveRoute("GET", "/users/new") || r.RemoveRoute("GET", "/users/new") {
+		t.Fatal("route should be removed exactly once")
+	}
+	if w := performRequest(r, "GET", "/users/new"); w.Body.String() != "user" {
+		t.Fatalf("expected fallback to /users/:id, got %q", w.Body.String())
+	}
+	if _, err := r.URL("new-user"); err == nil {
+		t.Fatal("name of removed route should be released")
+	}
+	if !r.RemoveRoute("GET", "/files/:name?") {
+		t.Fatal("optional route should be removed")
+	}
+	for _, path := range []string{"/files", "/files/a"} {
+		if w := performRequest(r, "GET", path); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+	if !r.RemoveRoute("GET", ":tenant.example.com/users/new") {
+		t.Fatal("host route should be removed")
+	}
+	r.GET("/users/new", func(c *Context) { c.String(http.StatusOK, "again") })
+	if w := performRequest(r, "GET", "/users/new"); w.Body.String() != "again" {
+		t.Fatalf("expected re-registered route, got %q", w.Body.String())
+	}
+}
+
+func TestRegisterWhileServing(t *testing.T) {
+	r := New()
+	r.GET("/ping", func(c *Context) { c.String(http.StatusOK, "pong") })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			path := fmt.Sprintf("/dynamic/%d", i)
+			r.GET(path, func(c *Context) {})
+			r.Use(func(c *Context) { c.Next() })
+			r.RemoveRoute("GET", path)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if w := performRequest(r, "GET", "/ping"); w.Body.String() != "pong" {
+			t.Fatalf("unexpected response %q", w.Body.String())
+		}
+	}
+	<-done
+}
+
+func TestMount(t *testing.T) {
+	r := New()
+	var trace []string
+	api := r.Group("/api", func(c *Context) {
+		trace = append(trace, "parent:"+c.Path)
+		c.Next()
+	})
+
+	child := New()
+	child.Use(func(c *Context) {
+		trace = append(trace, "child:"+c.Path)
+		c.Next()
+	})
+	child.GET("/users/:id", func(c *Context) {
+		c.String(http.StatusOK, "user %s", c.Param("id"))
+	})
+	child.GET("/", func(c *Context) {
+		c.String(http.StatusOK, "index")
+	})
+	api.Mount("/v2", child)
+
+	r.Mount("/raw/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s|%s", req.URL.Path, req.URL.RawPath)
+	}))
+	r.GET("/wrap", WrapF(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "wrapped")
+	}))
+	r.GET("/raw/own", WrapH(http.NotFoundHandler()))
+
+	if w := performRequest(r, "GET", "/api/v2/users/42"); w.Body.String() != "user 42" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	if !reflect.DeepEqual(trace, []string{"parent:/api/v2/users/42", "child:/users/42"}) {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+	for _, path := range []string{"/api/v2", "/api/v2/"} {
+		if w := performRequest(r, "GET", path); w.Body.String() != "index" {
+			t.Fatalf("%s: unexpected body %q", path, w.Body.String())
+		}
+	}
+	if w := performRequest(r, "GET", "/api/v2/missing"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 from child, got %d", w.Code)
+	}
+	for path, body := range map[string]string{
+		"/raw":         "/|",
+		"/raw/a/b":     "/a/b|",
+		"/raw/a%2Fb/c": "/a/b/c|/a%2Fb/c",
+		"/wrap":        "wrapped",
+	} {
+		if w := performRequest(r, "GET", path); w.Body.String() != body {
+			t.Fatalf("%s: expected %q, got %q", path, body, w.Body.String())
+		}
+	}
+	for _, method := range []string{"POST", "PROPFIND", "MKCOL"} {
+		if w := performRequest(r, method, "/raw/x"); w.Body.String() != "/x|" {
+			t.Fatalf("mount should accept %s, got %d %q", method, w.Code, w.Body.String())
+		}
+	}
+	if w := performRequest(r, "PROPFIND", "/elsewhere"); w.Code != http.StatusNotFound {
+		t.Fatalf("custom method outside mounts should 404, got %d", w.Code)
+	}
+	if w := performRequest(r, "GET", "/raw/own"); w.Code != http.StatusNotFound {
+		t.Fatalf("explicit route should take priority over mount, got %d", w.Code)
+	}
+}
+
+func TestUseRawPath(t *testing.T) {
+	newEngine := func(useRawPath, unescape bool) *Engine {
+		r := New()
+		r.UseRawPath = useRawPath
+		r.UnescapePathValues = unescape
+		r.GET("/files/:name", func(c *Context) {
+			c.String(http.StatusOK, "file:%s", c.Param("name"))
+		})
+		r.GET("/files/:name/:sub", func(c *Context) {
+			c.String(http.StatusOK, "name:%s sub:%s", c.Param("name"), c.Param("sub"))
+		})
+		r.GET("/static/*path", func(c *Context) {
+			c.String(http.StatusOK, "static:%s", c.Param("path"))
+		})
+		return r
+	}
+
+	for _, tc := range []struct {
+		useRawPath, unescape bool
+		path, body           string
+	}{
+		{false, true, "/files/a%2Fb", "name:a sub:b"},
+		{true, true, "/files/a%2Fb", "file:a/b"},
+		{true, false, "/files/a%2Fb", "file:a%2Fb"},
+		{true, true, "/files/a%20b", "file:a b"},
+		{true, true, "/files/plain", "file:plain"},
+		{true, true, "/static/x%2Fy/z%20w", "static:x/y/z w"},
+		{true, false, "/static/x%2Fy/z", "static:x%2Fy/z"},
+	} {
+		r := newEngine(tc.useRawPath, tc.unescape)
+		if w := performRequest(r, "GET", tc.path); w.Body.String() != tc.body {
+			t.Fatalf("UseRawPath=%v UnescapePathValues=%v %s: expected %q, got %q",
+				tc.useRawPath, tc.unescape, tc.path, tc.body, w.Body.String())
+		}
+	}
+}
+
+func TestPreMiddleware(t *testing.T) {
+	r := New()
+	var trace []string
+	r.Use(func(c *Context) {
+		trace = append(trace, "use")
+		c.Next()
+	})
+	r.Pre(func(c *Context) {
+		trace = append(trace, "pre")
+		if strings.HasPrefix(c.Path, "/zh/") {
+			c.Path = c.Path[3:]
+		}
+		c.Next()
+		trace = append(trace, "pre-after")
+	}, func(c *Context) {
+		if c.Method == http.MethodPost && c.Req.Header.Get("X-HTTP-Method-Override") != "" {
+			c.Method = c.Req.Header.Get("X-HTTP-Method-Override")
+		}
+	})
+	r.Pre(func(c *Context) {
+		if c.Path == "/blocked" {
+			c.Fail(http.StatusForbidden, "blocked")
+		}
+	})
+	r.GET("/hello/:name", func(c *Context) {
+		trace = append(trace, "handler")
+		c.String(http.StatusOK, "hello %s", c.Param("name"))
+	})
+	r.DELETE("/items/:id", func(c *Context) {
+		c.String(http.StatusOK, "deleted %s", c.Param("id"))
+	})
+
+	if w := performRequest(r, "GET", "/zh/hello/gee"); w.Body.String() != "hello gee" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	if !reflect.DeepEqual(trace, []string{"pre", "use", "handler", "pre-after"}) {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/items/1", nil)
+	req.Header.Set("X-HTTP-Method-Override", http.MethodDelete)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Body.String() != "deleted 1" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+
+	trace = nil
+	if w := performRequest(r, "GET", "/blocked"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+	if !reflect.DeepEqual(trace, []string{"pre", "pre-after"}) {
+		t.Fatalf("route should not run after Fail in Pre, trace %v", trace)
+	}
+
+	// Default 的 Recovery 同样覆盖 Pre 中间件
+	d := Default()
+	d.Pre(func(c *Context) {
+		if c.Path == "/panic" {
+			panic("pre panic")
+		}
+	})
+	d.GET("/ok", func(c *Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	if w := performRequest(d, "GET", "/panic"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	if w := performRequest(d, "GET", "/ok"); w.Body.String() != "ok" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestRules(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	data := `[
+		{"from": "/blog/:y<int>/:m<int>/:slug", "to": "/posts/:slug", "code": 301},
+		{"from": "/old/*rest", "to": "/new/*rest"},
+		{"from": "/legacy/:id", "to": "/old/items/:id"},
+		{"from": "/docs/*page", "to": "https://docs.example.com/v2/*page", "code": 302},
+		{"from": "/a", "to": "/b"},
+		{"from": "/b", "to": "/a"}
+	]`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules.Redirect("/self", "/self", http.StatusFound)
+
+	r := New()
+	r.Pre(rules.Handler())
+	r.GET("/posts/:slug", func(c *Context) {
+		c.String(http.StatusOK, "post %s", c.Param("slug"))
+	})
+	r.GET("/new/*rest", func(c *Context) {
+		c.String(http.StatusOK, "new %s (%s)", c.Param("rest"), c.Req.URL.Path)
+	})
+
+	for _, tc := range []struct {
+		path, location, body string
+		code                 int
+	}{
+		{"/blog/2023/05/hello%20gee?ref=rss", "/posts/hello%20gee?ref=rss", "", http.StatusMovedPermanently},
+		{"/blog/2023/may/hello", "", "", http.StatusNotFound},
+		{"/old/a/b%20c", "", "new a/b c (/old/a/b c)", http.StatusOK},
+		{"/legacy/42", "", "new items/42 (/legacy/42)", http.StatusOK},
+		{"/docs/guide/intro", "https://docs.example.com/v2/guide/intro", "", http.StatusFound},
+		{"/posts/direct", "", "post direct", http.StatusOK},
+		{"/a", "", "", http.StatusLoopDetected},
+		{"/self", "", "", http.StatusLoopDetected},
+	} {
+		w := performRequest(r, "GET", tc.path)
+		if w.Code != tc.code || w.Header().Get("Location") != tc.location || tc.body != "" && w.Body.String() != tc.body {
+			t.Fatalf("%s: expected %d %q %q, got %d %q %q", tc.path, tc.code, tc.location, tc.body,
+				w.Code, w.Header().Get("Location"), w.Body.String())
+		}
+	}
+
+	for _, rule := range []Rule{
+		{From: "/x/:id", To: "/y/:name"},
+		{From: "/x/:id", To: "/y", Code: 200},
+		{From: "/x/:id", To: "https://example.com/y"},
+	} {
+		if err := NewRules().tryAdd(rule); err == nil {
+			t.Fatalf("%+v should be rejected", rule)
+		}
+	}
+}
+
+func TestRejectedRuleLeavesNoTrace(t *testing.T) {
+	rules := NewRules().Rewrite("/a/:x/:y", "/b/:x/:y")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("conflicting optional rule should panic")
+			}
+		}()
+		rules.Rewrite("/a/:x/:y?", "/c/:x")
+	}()
+	r := New()
+	r.Pre(rules.Handler())
+	r.GET("/a/:x", func(c *Context) {
+		c.String(http.StatusOK, "a %s", c.Param("x"))
+	})
+	r.GET("/b/:x/:y", func(c *Context) {
+		c.String(http.StatusOK, "b %s %s", c.Param("x"), c.Param("y"))
+	})
+	if w := performRequest(r, "GET", "/a/1"); w.Body.String() != "a 1" {
+		t.Fatalf("rejected variant should not be applied, got %d %q", w.Code, w.Body.String())
+	}
+	if w := performRequest(r, "GET", "/a/1/2"); w.Body.String() != "b 1 2" {
+		t.Fatalf("existing rule should still apply, got %q", w.Body.String())
+	}
+}
+
+func TestNoRoute(t *testing.T) {
+	r := New()
+	var trace []string
+	r.Use(func(c *Context) {
+		trace = append(trace, "engine")
+		c.Next()
+	})
+	r.GET("/", func(c *Context) {})
+
+	if w := performRequest(r, "GET", "/missing"); w.Code != http.StatusNotFound || w.Body.String() != "404 NOT FOUND: /missing\n" {
+		t.Fatalf("unexpected default 404: %d %q", w.Code, w.Body.String())
+	}
+
+	r.NoRoute(func(c *Context) {
+		c.String(http.StatusNotFound, "html 404")
+	})
+	api := r.Group("/api", func(c *Context) {
+		trace = append(trace, "api")
+		c.Next()
+	})
+	api.NoRoute(func(c *Context) {
+		c.JSON(http.StatusNotFound, H{"error": "not found"})
+	})
+	v1 := api.Group("/v1", func(c *Context) {
+		trace = append(trace, "v1")
+		c.Next()
+	})
+	v1.GET("/users", func(c *Context) {})
+	r.Group("/users/:id/files").NoRoute(func(c *Context) {
+		c.String(http.StatusNotFound, "no file %s", c.Path)
+	})
+
+	for _, tc := range []struct {
+		path, body string
+		trace      []string
+	}{
+		{"/nothing", "html 404", []string{"engine"}},
+		{"/apix", "html 404", []string{"engine"}},
+		{"/api/nothing", "{\"error\":\"not found\"}\n", []string{"engine", "api"}},
+		{"/api/v1/nothing", "{\"error\":\"not found\"}\n", []string{"engine", "api", "v1"}},
+		{"/users/42/files/a.txt", "no file /users/42/files/a.txt", []string{"engine"}},
+	} {
+		trace = nil
+		w := performRequest(r, "GET", tc.path)
+		if w.Code != http.StatusNotFound || w.Body.String() != tc.body || !reflect.DeepEqual(trace, tc.trace) {
+			t.Fatalf("%s: unexpected %d %q %v", tc.path, w.Code, w.Body.String(), trace)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	r := New()
+	var trace []string
+	r.Use(func(c *Context) {
+		trace = append(trace, "mw:"+c.Path)
+		c.Next()
+	})
+	r.GET("/users/:id", func(c *Context) {
+		c.String(http.StatusOK, "user %s from %s", c.Param("id"), c.OriginalPath())
+	})
+	r.GET("/legacy/:uid", func(c *Context) {
+		c.Forward("/users/" + c.Param("uid"))
+	}, func(c *Context) {
+		trace = append(trace, "after forward")
+	})
+	r.GET("/loop", func(c *Context) {
+		c.Forward("/loop")
+	})
+	r.NoRoute(func(c *Context) {
+		c.Path = "/users/index"
+		r.HandleContext(c)
+	})
+
+	if w := performRequest(r, "GET", "/legacy/42"); w.Body.String() != "user 42 from /legacy/42" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	if !reflect.DeepEqual(trace, []string{"mw:/legacy/42", "mw:/users/42"}) {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+	if w := performRequest(r, "GET", "/app/settings"); w.Body.String() != "user index from /app/settings" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	if w := performRequest(r, "GET", "/loop"); w.Code != http.StatusLoopDetected {
+		t.Fatalf("expected 508, got %d", w.Code)
+	}
+
+	// 转交给另一个 Engine 之后，剩余的处理函数重新使用原来的 Engine
+	other := New()
+	other.GET("/x", func(c *Context) {
+		c.String(http.StatusOK, "b")
+	})
+	r.GET("/x", func(c *Context) {
+		c.String(http.StatusOK, "a")
+	})
+	r.GET("/fwd", func(c *Context) {
+		c.Path = "/x"
+		other.HandleContext(c)
+		c.Forward("/x")
+	})
+	if w := performRequest(r, "GET", "/fwd"); w.Body.String() != "ba" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestVersionRouting(t *testing.T) {
+	r := New()
+	r.DefaultVersion = "1"
+	r.Version("v1").GET("/users/:id", func(c *Context) {
+		c.String(http.StatusOK, "v1 %s", c.Param("id"))
+	})
+	v2 := r.Version("2")
+	v2.GET("/users/:id", func(c *Context) {
+		c.String(http.StatusOK, "v2 %s", c.Param("id"))
+	})
+	v2.Group("/admin").GET("/stats", func(c *Context) {
+		c.String(http.StatusOK, "v2 stats")
+	})
+	r.GET("/health", func(c *Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for _, tc := range []struct {
+		path, header, value, body string
+		code                      int
+	}{
+		{"/users/1", "", "", "v1 1", http.StatusOK},
+		{"/users/1", "X-API-Version", "2", "v2 1", http.StatusOK},
+		{"/users/1", "X-API-Version", "v2", "v2 1", http.StatusOK},
+		{"/users/1", "Accept", "text/html, application/vnd.acme.v2+json; q=0.9", "v2 1", http.StatusOK},
+		{"/users/1", "Accept", "application/vnd.acme.v1+json", "v1 1", http.StatusOK},
+		{"/users/1", "X-API-Version", "9", "v1 1", http.StatusOK},
+		{"/admin/stats", "X-API-Version", "2", "v2 stats", http.StatusOK},
+		{"/admin/stats", "", "", "", http.StatusNotFound},
+		{"/health", "X-API-Version", "2", "ok", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code || tc.body != "" && w.Body.String() != tc.body {
+			t.Fatalf("%s %s=%s: expected %d %q, got %d %q", tc.path, tc.header, tc.value, tc.code, tc.body, w.Code, w.Body.String())
+		}
+	}
+	if w := performRequest(r, "GET", "/users/1"); w.Header().Get("Vary") != "Accept, X-API-Version" {
+		t.Fatalf("unexpected Vary header %q", w.Header().Get("Vary"))
+	}
+
+	if !r.RemoveRoute("GET", "/users/:id@1") {
+		t.Fatal("versioned route should be removed")
+	}
+	if w := performRequest(r, "GET", "/users/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after removing default version, got %d", w.Code)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("duplicate versioned route should panic")
+			}
+		}()
+		v2.GET("/users/:id", func(c *Context) {})
+	}()
+}
+
+type userController struct{}
+
+func (userController) Index(c *Context) { c.String(http.StatusOK, "users") }
+func (userController) Show(c *Context)  { c.String(http.StatusOK, "user %s", c.Param("user_id")) }
+func (userController) Delete(c *Context) {
+	c.String(http.StatusOK, "delete user %s", c.Param("user_id"))
+}
+
+type postController struct{}
+
+func (postController) Create(c *Context) {
+	c.String(http.StatusCreated, "create post for %s", c.Param("user_id"))
+}
+func (postController) Show(c *Context) {
+	c.String(http.StatusOK, "post %s of %s", c.Param("post_id"), c.Param("user_id"))
+}
+func (postController) Update(c *Context) {
+	c.String(http.StatusOK, "update post %s of %s", c.Param("post_id"), c.Param("user_id"))
+}
+
+func TestResource(t *testing.T) {
+	r := New()
+	users := r.Group("/api").Resource("/users", userController{})
+	users.GET("/profile", func(c *Context) {
+		c.String(http.StatusOK, "profile %s", c.Param("user_id"))
+	})
+	users.Resource("/posts", &postController{})
+	r.router.handlers["GET-/api/users/:user_id/posts/:post_id"].Name("user.post")
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{"GET", "/api/users", "users"},
+		{"GET", "/api/users/1", "user 1"},
+		{"DELETE", "/api/users/1", "delete user 1"},
+		{"GET", "/api/users/1/profile", "profile 1"},
+		{"POST", "/api/users/1/posts", "create post for 1"},
+		{"GET", "/api/users/1/posts/2", "post 2 of 1"},
+		{"PATCH", "/api/users/1/posts/2", "update post 2 of 1"},
+		{"PUT", "/api/users/1/posts/2", "update post 2 of 1"},
+	} {
+		if w := performRequest(r, tc.method, tc.path); w.Body.String() != tc.body {
+			t.Fatalf("%s %s: expected %q, got %q", tc.method, tc.path, tc.body, w.Body.String())
+		}
+	}
+	if w := performRequest(r, "POST", "/api/users"); w.Code != http.StatusNotFound {
+		t.Fatalf("unimplemented action should not be routed, got %d", w.Code)
+	}
+
+	var got []string
+	for _, info := range r.Routes() {
+		got = append(got, info.Method+" "+info.Path)
+		if len(info.Middlewares) != 0 {
+			t.Fatalf("%s %s should have no middlewares, got %v", info.Method, info.Path, info.Middlewares)
+		}
+	}
+	expected := []string{
+		"GET /api/users",
+		"DELETE /api/users/:user_id",
+		"GET /api/users/:user_id",
+		"POST /api/users/:user_id/posts",
+		"GET /api/users/:user_id/posts/:post_id",
+		"PATCH /api/users/:user_id/posts/:post_id",
+		"PUT /api/users/:user_id/posts/:post_id",
+		"GET /api/users/:user_id/profile",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected routes %v", got)
+	}
+	if u, err := r.URL("user.post", "user_id", 1, "post_id", 2); err != nil || u != "/api/users/1/posts/2" {
+		t.Fatalf("unexpected URL %q %v", u, err)
+	}
+
+	// 与资源使用相同参数名的路由可以和资源路由共存
+	r.GET("/api/users/:user_id/avatar", func(c *Context) {
+		c.String(http.StatusOK, "avatar %s", c.Param("user_id"))
+	})
+	if w := performRequest(r, "GET", "/api/users/1/avatar"); w.Body.String() != "avatar 1" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("controller without actions should panic")
+			}
+		}()
+		r.Resource("/empty", struct{}{})
+	}()
+}
+
+func TestContextPool(t *testing.T) {
+	r := New()
+	var saved, copied *Context
+	r.GET("/users/:id", func(c *Context) {
+		if c.StatusCode != 0 || len(c.Params) != 1 {
+			t.Errorf("context not reset: %+v", c)
+		}
+		saved, copied = c, c.Copy()
+		c.String(http.StatusOK, "user %s", c.Param("id"))
+	})
+	r.GET("/loop", func(c *Context) {
+		c.Forward("/users/x")
+	})
+
+	performRequest(r, "GET", "/loop")
+	if w := performRequest(r, "GET", "/users/1"); w.Body.String() != "user 1" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	performRequest(r, "GET", "/users/2")
+	if copied.Param("id") != "2" || copied.Path != "/users/2" {
+		t.Fatalf("copy should keep its own params, got %v %s", copied.Params, copied.Path)
+	}
+	for _, c := range []*Context{saved, copied} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("writing after the request finished should panic")
+				}
+			}()
+			c.String(http.StatusOK, "late")
+		}()
+	}
+
+	// 上一个请求在另一个 Engine 中 panic，复用的 Context 仍然属于 r
+	other := New()
+	other.GET("/boom", func(c *Context) {
+		panic("boom")
+	})
+	other.GET("/x", func(c *Context) {
+		c.String(http.StatusOK, "b")
+	})
+	r.GET("/x", func(c *Context) {
+		c.String(http.StatusOK, "a")
+	})
+	r.GET("/fwd", Recovery(), func(c *Context) {
+		c.Path = "/boom"
+		other.HandleContext(c)
+	})
+	r.GET("/after", func(c *Context) {
+		c.Forward("/x")
+	})
+	performRequest(r, "GET", "/fwd")
+	for i := 0; i < 3; i++ {
+		if w := performRequest(r, "GET", "/after"); w.Body.String() != "a" {
+			t.Fatalf("reused context should belong to its own engine, got %q", w.Body.String())
+		}
+	}
+
+	w := &discardWriter{header: http.Header{}}
+	r.GET("/static/:id", func(c *Context) {})
+	req := httptest.NewRequest("GET", "/static/3", nil)
+	if allocs := testing.AllocsPerRun(100, func() { r.ServeHTTP(w, req) }); allocs != 0 {
+		t.Fatalf("expected 0 allocs per request, got %v", allocs)
+	}
+}
+
+type ctxKey struct{}
+
+func TestContextAsContext(t *testing.T) {
+	r := New()
+	started := make(chan struct{})
+	var err error
+	var value interface{}
+	r.GET("/slow", func(c *Context) {
+		value = c.Value(ctxKey{})
+		close(started)
+		wait := func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		}
+		err = wait(c)
+	})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "v"))
+	req := httptest.NewRequest("GET", "/slow", nil).WithContext(ctx)
+	go func() {
+		<-started
+		cancel()
+	}()
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if err != context.Canceled || value != "v" {
+		t.Fatalf("expected cancellation and request value, got %v %v", err, value)
+	}
+
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel = context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	var got time.Time
+	r.GET("/deadline", func(c *Context) {
+		got, _ = c.Deadline()
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/deadline", nil).WithContext(ctx))
+	if !got.Equal(deadline) {
+		t.Fatalf("expected deadline %v, got %v", deadline, got)
+	}
+}
+
+type testUser struct{ Name string }
+
+var testUserKey = NewKey[*testUser]("user")
+
+func TestContextKeys(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.tmpl"), []byte(`{{.user.Name}} {{.title}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := New()
+	r.LoadHTMLGlob(filepath.Join(dir, "*.tmpl"))
+	now := time.Now()
+	r.Use(func(c *Context) {
+		if _, ok := c.Get("user"); ok {
+			t.Error("keys should not leak between requests")
+		}
+		testUserKey.Set(c, &testUser{Name: "gee"})
+		c.Set("count", 3)
+		c.Set("at", now)
+		c.Set("tags", []string{"a"})
+		c.Next()
+	})
+	r.GET("/keys", func(c *Context) {
+		user, ok := testUserKey.Get(c)
+		if !ok || user.Name != "gee" || c.MustGet("user") != user {
+			t.Errorf("unexpected user %v %v", user, ok)
+		}
+		if c.GetInt("count") != 3 || !c.GetTime("at").Equal(now) || !reflect.DeepEqual(c.GetStringSlice("tags"), []string{"a"}) {
+			t.Errorf("unexpected typed values")
+		}
+		if c.GetString("count") != "" || c.GetInt("missing") != 0 {
+			t.Errorf("mismatched types should return zero values")
+		}
+		if c.Value("count") != 3 || c.Value(testUserKey) != user {
+			t.Errorf("keys should be exposed through Value")
+		}
+		if _, ok := NewKey[string]("count").Get(c); ok {
+			t.Errorf("typed key with the wrong type should not match")
+		}
+		cp := c.Copy()
+		c.Set("count", 4)
+		if cp.GetInt("count") != 3 {
+			t.Errorf("copy should hold its own keys")
+		}
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/page", func(c *Context) {
+		c.HTML(http.StatusOK, "hello.tmpl", H{"title": "page"})
+	})
+
+	for i := 0; i < 2; i++ {
+		if w := performRequest(r, "GET", "/keys"); w.Body.String() != "ok" {
+			t.Fatalf("unexpected body %q", w.Body.String())
+		}
+	}
+	if w := performRequest(r, "GET", "/page"); w.Body.String() != "gee page" {
+		t.Fatalf("unexpected template output %q", w.Body.String())
+	}
+
+	c := &Context{}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("MustGet on a missing key should panic")
+			}
+		}()
+		c.MustGet("missing")
+	}()
+}
+
+func TestRejectedRouteLeavesNoTrace(t *testing.T) {
+	r := New()
+	r.GET("/a/:x", func(c *Context) { c.String(http.StatusOK, "x") })
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("conflicting optional route should panic")
+			}
+		}()
+		r.GET("/a/:y?", func(c *Context) { c.String(http.StatusOK, "rejected") })
+	}()
+	if w := performRequest(r, "GET", "/a"); w.Code != http.StatusNotFound {
+		t.Fatalf("rejected variant should not be routed, got %d %q", w.Code, w.Body.String())
+	}
+	if w := performRequest(r, "GET", "/a/1"); w.Body.String() != "x" {
+		t.Fatalf("existing route should still match, got %q", w.Body.String())
+	}
+	if len(r.Routes()) != 1 {
+		t.Fatalf("expected 1 route, got %v", r.Routes())
+	}
+	r.GET("/a", func(c *Context) { c.String(http.StatusOK, "a") })
+	if w := performRequest(r, "GET", "/a"); w.Body.String() != "a" {
+		t.Fatalf("path of the rejected variant should be registrable, got %q", w.Body.String())
+	}
 }
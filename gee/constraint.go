@@ -0,0 +1,119 @@
+package gee
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// 路由参数约束
+// :name 后面可以用 <> 跟一个约束，只有满足约束的路由段才能匹配该参数，例如
+//
+//	/users/:id<int>          id 必须是 int64 范围内的整数，<uint> 同理为 uint64 范围内的非负整数
+//	/posts/:slug<[a-z0-9-]+> slug 必须匹配正则表达式 ^(?:[a-z0-9-]+)$
+//	/orders/:uuid<uuid>      uuid 必须是 8-4-4-4-12 格式的十六进制 UUID
+//
+// 不满足约束的路由段会回退到同一位置的其他候选节点继续匹配。
+
+// paramConstraint 路由参数的约束
+type paramConstraint struct {
+	text  string            // 约束原文，例如 int、[a-z0-9-]+
+	match func(string) bool // 判断路由段是否满足约束
+}
+
+// builtinConstraints 内置的约束，其他约束按正则表达式处理
+var builtinConstraints = map[string]func(string) bool{
+	"int":   isInt,
+	"uint":  isUint,
+	"uuid":  isUUID,
+	"alpha": isAlpha,
+}
+
+// constraintCache 缓存已经解析过的约束，相同的约束在不同路由之间共用同一个正则表达式
+var constraintCache sync.Map
+
+// getConstraint 返回约束 text 对应的 paramConstraint，text 既不是内置约束也不是合法的正则表达式时直接 panic
+func getConstraint(text string) *paramConstraint {
+	if pc, ok := constraintCache.Load(text); ok {
+		return pc.(*paramConstraint)
+	}
+	match, ok := builtinConstraints[text]
+	if !ok {
+		re, err := regexp.Compile("^(?:" + text + ")$")
+		if err != nil {
+			panic("gee: invalid param constraint <" + text + ">: " + err.Error())
+		}
+		match = re.MatchString
+	}
+	pc, _ := constraintCache.LoadOrStore(text, &paramConstraint{text: text, match: match})
+	return pc.(*paramConstraint)
+}
+
+// isDigits 判断 s 是否为非空的十进制数字串
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isUint 判断 s 是否为 uint64 范围内的十进制数字串。先检查字符再解析，
+// 只有超出范围时 strconv 才会分配错误对象，匹配失败的常见情况不分配内存
+func isUint(s string) bool {
+	if !isDigits(s) {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// isInt 判断 s 是否为 int64 范围内、可以带负号的十进制整数
+func isInt(s string) bool {
+	digits := s
+	if len(digits) > 1 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if !isDigits(digits) {
+		return false
+	}
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+// isAlpha 判断 s 是否为非空的纯字母串
+func isAlpha(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// isUUID 判断 s 是否为 8-4-4-4-12 格式的十六进制 UUID，不区分大小写
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if c != '-' {
+				return false
+			}
+			continue
+		}
+		if !('0' <= c && c <= '9' || 'a' <= c|0x20 && c|0x20 <= 'f') {
+			return false
+		}
+	}
+	return true
+}
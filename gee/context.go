@@ -6,40 +6,203 @@ package gee
 //提供了快速构造String/Data/JSON/HTML响应的方法。
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type H map[string]interface{}
 
+// Param 一个路由参数，由参数名 Key 和匹配到的值 Value 组成
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params 路由参数列表，按参数在路由中出现的顺序排列。
+// 参数个数通常很少，顺序查找比 map 更快，而且切片可以在请求之间复用，不需要每次分配
+type Params []Param
+
+// Get 返回第一个名为 name 的参数值，以及该参数是否存在
+func (ps Params) Get(name string) (string, bool) {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// ByName 返回第一个名为 name 的参数值，不存在时返回空字符串
+func (ps Params) ByName(name string) string {
+	value, _ := ps.Get(name)
+	return value
+}
+
+// unescape 解码参数值中的转义字符，*catchAll 的值按 / 分段后逐段解码，不合法的转义保持原样
+func (ps Params) unescape() {
+	for i := range ps {
+		value := ps[i].Value
+		if strings.IndexByte(value, '%') < 0 {
+			continue
+		}
+		parts := strings.Split(value, "/")
+		for j, part := range parts {
+			if unescaped, err := url.PathUnescape(part); err == nil {
+				parts[j] = unescaped
+			}
+		}
+		ps[i].Value = strings.Join(parts, "/")
+	}
+}
+
 // Context struct 用于封装 HTTP 请求和响应的相关信息，以及相关的处理函数
+//
+// Context 由 Engine 通过 sync.Pool 复用，只在处理请求期间有效：ServeHTTP 返回后，
+// 同一个 Context 会被重置并用于处理其他请求。需要在处理函数返回后继续使用时（例如交给新的 goroutine），
+// 必须先调用 c.Copy() 得到一个副本。请求结束后通过原来的 Context 写响应会直接 panic，便于尽早发现这类错误
 type Context struct {
 	// origin objects
 	Writer http.ResponseWriter //HTTP 响应的写入器，用于向客户端发送响应数据
 	Req    *http.Request       //HTTP 请求的指针，用于获取客户端发送的请求信息，例如请求方法、请求头、请求体等
 	// request info
-	Path   string            //请求的路径，即 URL 中的路径部分
-	Method string            //请求的方法，例如 GET、POST 等
-	Params map[string]string //请求中的路由参数，由路由器解析后存储在此字段中
+	Path   string //请求的路径，即 URL 中的路径部分
+	Method string //请求的方法，例如 GET、POST 等
+	Params Params //请求中的路由参数，由路由器解析后存储在此字段中
 	// response info
 	StatusCode int //响应的状态码，例如 200、404 等
 	// middleware
 	handlers []HandlerFunc //处理函数的切片，用于存储当前请求所需要执行的所有处理函数
 	index    int           //当前请求需要执行的处理函数在 handlers 切片中的索引
+	forwards int           //通过 HandleContext 重新路由的次数，用于检测循环
+	// request-scoped keys
+	mu   sync.RWMutex           //保护 keys 的并发读写
+	keys map[string]interface{} //通过 Set 保存的键值对，第一次 Set 时创建
 	// engine pointer
 	engine *Engine //指向引擎的指针，用于访问引擎中的一些全局配置和方法
 }
 
-// newContext 创建一个新的context对象
-func newContext(w http.ResponseWriter, req *http.Request) *Context {
-	return &Context{
-		Writer: w,
-		Req:    req,
-		Path:   req.URL.Path,
-		Method: req.Method,
-		index:  -1,
+// reset 重置从 sync.Pool 中取出的 Context，用于 engine 处理新的请求。
+// 除了 Params 底层的数组外，上一个请求留下的状态都会被清空；engine 也会重新设置，
+// 上一个请求通过其他 Engine 的 HandleContext 处理时（例如中途 panic）不会影响之后的请求
+func (c *Context) reset(engine *Engine, w http.ResponseWriter, req *http.Request) {
+	c.engine = engine
+	c.Writer = w
+	c.Req = req
+	c.Path = req.URL.Path
+	c.Method = req.Method
+	c.Params = c.Params[:0]
+	c.StatusCode = 0
+	c.handlers = nil
+	c.index = -1
+	c.forwards = 0
+	c.keys = nil
+}
+
+// release 在请求处理完毕、放回 sync.Pool 之前调用，清除对请求和响应的引用，
+// 并把 Writer 换成 releasedWriter，使请求结束后写响应的错误用法立即暴露出来
+func (c *Context) release() {
+	c.Writer = releasedWriter{}
+	c.Req = nil
+	c.handlers = nil
+	c.keys = nil
+}
+
+// Copy 返回一个可以在请求结束后继续使用的副本，用于把 Context 交给新的 goroutine：
+//
+//	cc := c.Copy()
+//	go func() { log.Println(cc.Path, cc.Param("id")) }()
+//
+// 副本复制了请求、路径、路由参数和 Set 保存的键值对，但不能再写响应，也不能调用 Next，
+// 通过副本写响应会直接 panic
+func (c *Context) Copy() *Context {
+	cp := &Context{
+		Writer:     releasedWriter{},
+		Req:        c.Req,
+		Path:       c.Path,
+		Method:     c.Method,
+		StatusCode: c.StatusCode,
+		index:      len(c.handlers),
+		engine:     c.engine,
+	}
+	cp.Params = make(Params, len(c.Params))
+	copy(cp.Params, c.Params)
+	if keys := c.Keys(); len(keys) > 0 {
+		cp.keys = keys
+	}
+	return cp
+}
+
+// Context 实现了 context.Context，可以直接传给数据库、RPC 等需要 context.Context 的调用，
+// Deadline、Done、Err 和 Value 都委托给请求的 c.Req.Context()，客户端断开连接时下游调用会随之取消
+var _ context.Context = (*Context)(nil)
+
+// Deadline 返回请求 context 的截止时间
+func (c *Context) Deadline() (deadline time.Time, ok bool) {
+	if c.Req == nil {
+		return
 	}
+	return c.Req.Context().Deadline()
+}
+
+// Done 返回请求 context 的 Done channel，请求结束后的 Context 返回 nil，即永远不会被取消
+func (c *Context) Done() <-chan struct{} {
+	if c.Req == nil {
+		return nil
+	}
+	return c.Req.Context().Done()
+}
+
+// Err 返回请求 context 被取消的原因
+func (c *Context) Err() error {
+	if c.Req == nil {
+		return nil
+	}
+	return c.Req.Context().Err()
+}
+
+// Value 返回 key 对应的值：key 为 string 或 Key[T] 时先查找通过 Set 保存的键值对，没有时再查找请求 context
+func (c *Context) Value(key interface{}) interface{} {
+	var name string
+	switch k := key.(type) {
+	case string:
+		name = k
+	case interface{ contextKey() string }:
+		name = k.contextKey()
+	}
+	if name != "" {
+		if value, ok := c.Get(name); ok {
+			return value
+		}
+	}
+	if c.Req == nil {
+		return nil
+	}
+	return c.Req.Context().Value(key)
+}
+
+// releasedWriter 请求结束后或者 Copy 得到的 Context 使用的 http.ResponseWriter，任何写操作都会 panic
+type releasedWriter struct{}
+
+// Header 请求已经结束，返回一个不会被写出的空 Header
+func (releasedWriter) Header() http.Header {
+	return http.Header{}
+}
+
+// Write 请求已经结束，直接 panic
+func (releasedWriter) Write([]byte) (int, error) {
+	panic("gee: write to a Context after the request finished, use c.Copy() outside the handler chain")
+}
+
+// WriteHeader 请求已经结束，直接 panic
+func (releasedWriter) WriteHeader(int) {
+	panic("gee: write to a Context after the request finished, use c.Copy() outside the handler chain")
 }
 
 // Next index是记录当前执行到第几个中间件，当在中间件中调用Next方法时，
@@ -56,11 +219,27 @@ func (c *Context) Next() {
 
 // Param  我们将解析后的参数存储到Params中，通过c.Param("lang")的方式获取到对应的值
 func (c *Context) Param(key string) string {
-	value := c.Params[key]
+	value := c.Params.ByName(key)
 	DPrintf("[Context]Params:%s\n", value)
 	return value
 }
 
+// ParamInt 把名为 key 的路由参数解析为 int。路由参数只保存字符串值，每次调用都会解析一次；
+// 配合 :key<int> 约束使用时，参数一定是 int64 范围内的整数，在 int 为 64 位的平台上解析不会失败
+func (c *Context) ParamInt(key string) (int, error) {
+	return strconv.Atoi(c.Param(key))
+}
+
+// ParamInt64 把名为 key 的路由参数解析为 int64，配合 :key<int> 约束使用时解析不会失败
+func (c *Context) ParamInt64(key string) (int64, error) {
+	return strconv.ParseInt(c.Param(key), 10, 64)
+}
+
+// ParamUint64 把名为 key 的路由参数解析为 uint64，配合 :key<uint> 约束使用时解析不会失败
+func (c *Context) ParamUint64(key string) (uint64, error) {
+	return strconv.ParseUint(c.Param(key), 10, 64)
+}
+
 // PostForm  获取URL中的参数  如http://gee.com?key=value/
 func (c *Context) PostForm(key string) string {
 	DPrintf("[Context]PostFormValue:%s\n", c.Req.FormValue(key))
@@ -102,13 +281,19 @@ func (c *Context) String(code int, format string, values ...interface{}) {
 // 并将其写入 HTTP 响应正文中。如果在编码期间出现错误，则返回 HTTP 500 内部服务器错误，并在响应正文中包含错误消息
 func (c *Context) JSON(code int, object interface{}) {
 	c.SetHeader("Content-Type", "application/json")
-	c.StatusCode = code
+	c.Status(code)
 	encoder := json.NewEncoder(c.Writer)
 	if err := encoder.Encode(object); err != nil {
 		http.Error(c.Writer, err.Error(), 500)
 	}
 }
 
+// Redirect 以状态码 code 重定向到 location
+func (c *Context) Redirect(code int, location string) {
+	c.StatusCode = code
+	http.Redirect(c.Writer, c.Req, location, code)
+}
+
 // Data 写入响应数据
 func (c *Context) Data(code int, data []byte) {
 	c.Status(code)
@@ -116,14 +301,38 @@ func (c *Context) Data(code int, data []byte) {
 }
 
 // HTML 将html写入Writer中
+// data 为 nil 或 H（map[string]interface{}）时，通过 Set 保存的键值对会一并传给模板，模板中可以直接使用 {{.user}}，
+// data 中的同名键优先；data 为其他类型时只传 data 本身
 func (c *Context) HTML(code int, name string, data interface{}) {
 	c.SetHeader("Content-Type", "text/html")
 	c.Status(code)
-	if err := c.engine.htmlTemplates.ExecuteTemplate(c.Writer, name, data); err != nil {
+	if err := c.engine.htmlTemplates.ExecuteTemplate(c.Writer, name, c.templateData(data)); err != nil {
 		c.Fail(500, err.Error())
 	}
 }
 
+// templateData 把通过 Set 保存的键值对合并到模板数据 data 中，不修改 data 本身
+func (c *Context) templateData(data interface{}) interface{} {
+	var m map[string]interface{}
+	switch v := data.(type) {
+	case nil:
+	case H:
+		m = v
+	case map[string]interface{}:
+		m = v
+	default:
+		return data
+	}
+	keys := c.Keys()
+	if len(keys) == 0 {
+		return data
+	}
+	for k, v := range m {
+		keys[k] = v
+	}
+	return H(keys)
+}
+
 // Fail 将 HTTP 响应状态码设置为指定的 code，并将一个包含错误信息的 JSON 响应发送给客户端，
 // 然后将当前处理程序的索引设置为 handlers 切片的末尾，以确保在 handlers 切片中的后续处理程序不会被执行。
 // 这个方法通常在处理请求时遇到错误时被调用，以及在中间件中进行错误处理时使用。
@@ -131,3 +340,14 @@ func (c *Context) Fail(code int, err string) {
 	c.index = len(c.handlers)
 	c.JSON(code, H{"message": err})
 }
+
+// Forward 把请求转交给路径 path 对应的路由处理，等价于修改 c.Path 后调用 Engine.HandleContext
+func (c *Context) Forward(path string) {
+	c.Path = path
+	c.engine.HandleContext(c)
+}
+
+// OriginalPath 返回客户端请求的原始路径。Pre 中间件、重写规则和 Forward 只修改 c.Path，不会修改 c.Req.URL
+func (c *Context) OriginalPath() string {
+	return c.Req.URL.Path
+}
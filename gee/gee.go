@@ -3,7 +3,8 @@ package gee
 import (
 	"html/template"
 	"net/http"
-	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // HandlerFunc defines the request handler used by gee
@@ -14,23 +15,142 @@ type Engine struct {
 	router *router
 	groups []*RouterGroup // store all groups
 	*RouterGroup
+
+	// HandleMethodNotAllowed 为 true 时，若请求路径在其他请求方法的路由树中能够匹配，
+	// 则返回 405 Method Not Allowed 并在 Allow 头中列出允许的方法，而不是 404
+	HandleMethodNotAllowed bool
+	// HandleOPTIONS 为 true 时，没有显式注册 OPTIONS 路由的路径会自动应答 OPTIONS 请求，
+	// Allow 头中列出该路径上已注册的全部请求方法，默认开启
+	HandleOPTIONS bool
+	// HandleHEAD 为 true 时，没有显式注册 HEAD 路由的路径会回退到对应的 GET 路由，
+	// 响应体被丢弃但保留 Content-Length，默认开启
+	HandleHEAD bool
+	// RedirectTrailingSlash 为 true 时，请求路径只有末尾的 / 与已注册路由不同时，
+	// 重定向到已注册的路由，而不是直接按该路由处理
+	RedirectTrailingSlash bool
+	// RedirectFixedPath 为 true 时，请求路径需要规范化（合并多余的 /，处理 . 和 ..）
+	// 或者修正大小写才能匹配到路由时，重定向到规范的路由路径
+	RedirectFixedPath bool
+	// StrictSlash 为 true 时末尾的 / 是有意义的：/hello/ 只能匹配 /hello/，不再回退到 /hello，
+	// 此时配合 RedirectTrailingSlash 可以把另一种写法重定向过来
+	StrictSlash bool
+	// UseRawPath 为 true 时，请求路径中含有转义字符（URL.RawPath 不为空）时在转义形式的路径上查找路由，
+	// 此时 /files/a%2Fb 中的 a%2Fb 作为一个路由段匹配 /files/:name，而不是被拆成两段
+	UseRawPath bool
+	// UnescapePathValues 为 true 时，UseRawPath 匹配到的参数值会被解码，*catchAll 的值按 / 分段逐段解码，
+	// 为 false 时参数值保持转义形式，默认开启
+	UnescapePathValues bool
+	// DefaultVersion 请求没有指定 API 版本，或者指定的版本没有注册时使用的版本，见 RouterGroup.Version
+	DefaultVersion string
+	noMethod       []HandlerFunc // 405 时执行的处理函数链
+	// 查找路由之前执行的中间件，最后一个是 routeHandler。以原子快照的形式发布，
+	// 处理请求时不需要为了读取它再获取一次 router 的读锁
+	pre  atomic.Pointer[[]HandlerFunc]
+	pool sync.Pool // 复用 Context，见 Context 的说明
 }
 
 // New is the constructor of gee.Engine
 func New() *Engine {
-	engine := &Engine{router: NewRouter()}
+	engine := &Engine{router: NewRouter(), HandleOPTIONS: true, HandleHEAD: true, UnescapePathValues: true}
 	engine.RouterGroup = &RouterGroup{engine: engine}
 	engine.groups = []*RouterGroup{engine.RouterGroup}
+	engine.pool.New = func() interface{} {
+		return &Context{engine: engine}
+	}
 	return engine
 }
 
 // Default use Logger() & Recovery middlewares
+// Recovery 同时注册为 Pre 中间件，之后通过 Pre 添加的中间件（例如 Rules.Handler）panic 时同样返回 500
 func Default() *Engine {
 	engine := New()
+	engine.Pre(Recovery())
 	engine.Use(Logger(), Recovery())
 	return engine
 }
 
+// NoMethod 设置 405 时执行的处理函数链，需要配合 HandleMethodNotAllowed 使用。
+// 与普通路由一样，这些处理函数会排在请求路径所属分组的中间件之后执行，
+// 执行前 Allow 头已经写好，状态码和响应体由处理函数自行决定
+func (engine *Engine) NoMethod(handlers ...HandlerFunc) {
+	engine.router.mu.Lock()
+	defer engine.router.mu.Unlock()
+	engine.noMethod = handlers
+}
+
+// Pre 添加在查找路由之前执行的中间件。与 Use 添加的中间件不同，Pre 中间件对全部请求生效（包括 404），
+// 并且可以修改 c.Path 和 c.Method，之后按修改后的值查找路由，例如去掉语言前缀：
+//
+//	r.Pre(func(c *gee.Context) {
+//		if strings.HasPrefix(c.Path, "/zh/") {
+//			c.Path = c.Path[3:]
+//		}
+//	})
+//
+// 查找路由以及路由的处理函数链都在 Pre 中间件的 c.Next() 中执行，c.Next() 之后的代码在请求处理完毕后执行；
+// Pre 中间件调用 c.Fail 等中止处理函数链时，不再查找路由。此时 c.Params 还没有值。
+// Pre 中间件在 Use 添加的中间件之外执行，Use 添加的 Logger、Recovery 不会记录或捕获 Pre 中间件中的 panic，
+// 需要时在 Pre 中间件的最前面加上 Recovery，Default 已经这样做了
+func (engine *Engine) Pre(middlewares ...HandlerFunc) {
+	engine.router.mu.Lock()
+	defer engine.router.mu.Unlock()
+	var old []HandlerFunc
+	if p := engine.pre.Load(); p != nil {
+		old = (*p)[:len(*p)-1]
+	}
+	pre := make([]HandlerFunc, 0, len(old)+len(middlewares)+1)
+	pre = append(pre, old...)
+	pre = append(pre, middlewares...)
+	pre = append(pre, routeHandler)
+	engine.pre.Store(&pre)
+}
+
+// maxForwards 单个请求最多通过 HandleContext 重新路由的次数，超过时认为发生了循环
+const maxForwards = 10
+
+// HandleContext 在同一个请求内按 c.Path、c.Method 重新查找路由并执行匹配到的处理函数链，
+// 用于把请求转交给另一个路由而不需要客户端重定向，例如旧地址的别名、单页应用的兜底页面：
+//
+//	r.NoRoute(func(c *gee.Context) {
+//		c.Path = "/index.html"
+//		r.HandleContext(c)
+//	})
+//
+// 重新路由时会清空路由参数，Pre 中间件不会再次执行；c.Req.URL 保持不变，原始路径可以通过 c.OriginalPath 读取。
+// 转交后的处理函数链执行完毕后，调用方所在处理函数链中剩余的处理函数不再执行，已经进入的中间件在 c.Next() 返回后照常执行。
+// 在另一个 Engine 上调用时，转交后的处理函数链使用该 Engine 的路由、模板等配置，返回后 c 重新属于原来的 Engine。
+// 同一个请求重新路由超过 maxForwards 次时认为发生了循环，返回 508 Loop Detected
+func (engine *Engine) HandleContext(c *Context) {
+	c.forwards++
+	if c.forwards > maxForwards {
+		c.Fail(http.StatusLoopDetected, "gee: too many forwards for "+c.OriginalPath())
+		return
+	}
+	handlers, owner := c.handlers, c.engine
+	c.engine = engine
+	c.index = -1
+	engine.router.handle(c)
+	c.handlers, c.index, c.engine = handlers, len(handlers), owner
+}
+
+// routeHandler Pre 中间件链的最后一个处理函数，按当前的 c.Path、c.Method 查找路由并执行路由的处理函数链，
+// 执行完毕后恢复 Pre 中间件链，使外层的 c.Next() 能够正常返回
+func routeHandler(c *Context) {
+	handlers, index := c.handlers, c.index
+	c.index = -1
+	c.engine.router.handle(c)
+	c.handlers, c.index = handlers, index
+}
+
+// RemoveRoute 删除通过 GET、POST 等方法注册的路由，method 和 pattern 与注册时相同，pattern 包含分组前缀；
+// 绑定 Host 的路由需要在 pattern 前面加上 Host 模式，例如 RemoveRoute("GET", ":tenant.example.com/users")。
+// 路由存在并被删除时返回 true。
+// 与注册路由一样，RemoveRoute 可以在 Run 之后、处理请求的同时调用，正在执行的请求不受影响，
+// 之后到来的请求不会再匹配到被删除的路由
+func (engine *Engine) RemoveRoute(method string, pattern string) bool {
+	return engine.router.removeRoute(method, pattern)
+}
+
 // SetFuncMap 方法是用来设置模板渲染时需要用到的自定义函数的FuncMap 是一个 map 类型，
 // 其中 key 是函数名，value 是一个空接口，这个接口的实现可以是任何类型的函数。在模板渲染时，
 // 我们可以通过函数名调用对应的自定义函数。这个方法的作用就是将这个 FuncMap
@@ -44,29 +164,38 @@ func (engine *Engine) SetFuncMap(funcMap template.FuncMap) {
 // 模板文件可以包含动态内容和控制结构，可以使用 Go 内置的模板语言进行定义和渲染。
 // 模板语言是一种类似于 JSP 和 PHP 的模板技术，用于将模板和数据结合起来生成最终的 HTML 页面。
 // template.Must 函数用于将模板对象与错误进行绑定，如果模板文件解析失败，则程序将抛出 panic 异常。
+// 除了 SetFuncMap 设置的函数外，模板中还可以使用 gee 内置的函数，例如 url，同名时以 SetFuncMap 设置的为准。
 func (engine *Engine) LoadHTMLGlob(pattern string) {
-	engine.htmlTemplates = template.Must(template.New("").Funcs(engine.funcMap).ParseGlob(pattern))
+	engine.htmlTemplates = template.Must(template.New("").Funcs(engine.builtinFuncs()).Funcs(engine.funcMap).ParseGlob(pattern))
+}
+
+// builtinFuncs gee 内置的模板函数
+//
+//	url  按路由名生成 URL，参数与 Engine.URL 相同，例如 {{url "user" "id" .ID}}
+func (engine *Engine) builtinFuncs() template.FuncMap {
+	return template.FuncMap{
+		"url": engine.URL,
+	}
 }
 
 // Run defines the method to start a http server
+// Run 之后仍然可以注册、删除路由和调用 Use，路由表的修改对之后到来的请求生效
 func (engine *Engine) Run(addr string) (err error) {
 	return http.ListenAndServe(addr, engine)
 }
 
 // ServeHTTP 实现Handler接口，自定义HTTP请求的处理方式
-// 首先遍历所有的路由组，判断请求路径是否以路由组的前缀开头，
-// 如果是，将路由组的中间件添加到中间件列表中。然后创建一个新的 Context 对象，
-// 并将中间件列表赋值给 handlers 字段，将当前 Engine 对象赋值给 engine 字段。
-// 最后调用 router 对象的 handle 方法处理请求
+// 从 sync.Pool 中取出一个 Context 并重置，然后调用 router 对象的 handle 方法处理请求，处理完毕后放回 sync.Pool。
+// 分组中间件在注册路由时已经和处理函数合并成处理函数链，这里不再需要逐个遍历分组去匹配前缀
 func (engine *Engine) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	var middlewares []HandlerFunc
-	for _, group := range engine.groups {
-		if strings.HasPrefix(req.URL.Path, group.prefix) {
-			middlewares = append(middlewares, group.middlewares...)
-		}
+	c := engine.pool.Get().(*Context)
+	c.reset(engine, w, req)
+	if pre := engine.pre.Load(); pre != nil {
+		c.handlers = *pre
+		c.Next()
+	} else {
+		engine.router.handle(c)
 	}
-	c := newContext(w, req)
-	c.handlers = middlewares
-	c.engine = engine
-	engine.router.handle(c)
+	c.release()
+	engine.pool.Put(c)
 }
@@ -0,0 +1,118 @@
+package gee
+
+import "strings"
+
+// 基于 Host 的路由
+// 同一个进程可以同时服务 api.example.com、admin.example.com 等多个域名，
+// 通过 Engine.Host 创建的分组只匹配 Host 符合模式的请求。Host 模式按 . 分成若干段，每一段可以是
+//
+//	固定的域名段，例如 example，不区分大小写
+//	参数段，例如 :tenant，匹配任意一段，值可以通过 c.Param("tenant") 读取，同样支持 :tenant<[a-z]+> 这样的约束
+//
+// 请求先按注册顺序尝试 Host 模式匹配的分组，没有匹配到路由时再回退到不绑定 Host 的路由。
+
+// hostLabel Host 模式中的一段
+type hostLabel struct {
+	text       string           // 固定段为原文，参数段为参数名
+	param      bool             // 是否为参数段
+	constraint *paramConstraint // 参数段的约束，没有约束时为 nil
+}
+
+// hostPattern 解析后的 Host 模式
+type hostPattern struct {
+	pattern string
+	labels  []hostLabel
+	params  int // 参数段的个数
+}
+
+// hostRoutes 绑定到同一个 Host 模式的路由树
+type hostRoutes struct {
+	host  *hostPattern
+	roots map[string]*node
+}
+
+// parseHostPattern 解析 Host 模式，例如 :tenant.example.com，模式不合法时直接 panic
+func parseHostPattern(pattern string) *hostPattern {
+	if pattern == "" {
+		panic("gee: host pattern must not be empty")
+	}
+	h := &hostPattern{pattern: pattern}
+	for _, label := range strings.Split(pattern, ".") {
+		if label == "" {
+			panic("gee: empty label in host pattern '" + pattern + "'")
+		}
+		if label[0] != ':' {
+			h.labels = append(h.labels, hostLabel{text: label})
+			continue
+		}
+		segs := parseSegments(label)
+		if len(segs) != 1 || segs[0].text == "" || segs[0].optional {
+			panic("gee: invalid param '" + label + "' in host pattern '" + pattern + "'")
+		}
+		hl := hostLabel{text: segs[0].text, param: true}
+		if segs[0].constraint != "" {
+			hl.constraint = getConstraint(segs[0].constraint)
+		}
+		h.labels = append(h.labels, hl)
+		h.params++
+	}
+	return h
+}
+
+// match 判断 host 是否符合模式，匹配成功时把参数段的值追加到 ps 中，失败时 ps 保持不变
+func (h *hostPattern) match(host string, ps *Params) bool {
+	mark := len(*ps)
+	for i, label := range h.labels {
+		end := strings.IndexByte(host, '.')
+		if end < 0 {
+			end = len(host)
+		}
+		value := host[:end]
+		if (i == len(h.labels)-1) != (end == len(host)) {
+			*ps = (*ps)[:mark]
+			return false
+		}
+		if label.param {
+			if value == "" || label.constraint != nil && !label.constraint.match(value) {
+				*ps = (*ps)[:mark]
+				return false
+			}
+			*ps = append(*ps, Param{Key: label.text, Value: value})
+		} else if !strings.EqualFold(value, label.text) {
+			*ps = (*ps)[:mark]
+			return false
+		}
+		if end < len(host) {
+			host = host[end+1:]
+		}
+	}
+	return true
+}
+
+// stripPort 去掉 host 中的端口号，兼容 [::1]:8080 这样的 IPv6 地址
+func stripPort(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && i > strings.LastIndexByte(host, ']') {
+		return host[:i]
+	}
+	return host
+}
+
+// Host 返回一个绑定到 Host 模式 pattern 的分组，例如
+//
+//	api := r.Host(":tenant.example.com")
+//	api.GET("/users", handler) // 只匹配 Host 为 xxx.example.com 的请求，c.Param("tenant") 为 xxx
+//
+// 分组继承 Engine 上的中间件，在它下面创建的子分组同样绑定到这个 Host 模式。
+// Host 不匹配任何模式，或者在匹配的分组中找不到路由时，回退到不绑定 Host 的路由
+func (engine *Engine) Host(pattern string) *RouterGroup {
+	parseHostPattern(pattern)
+	group := &RouterGroup{
+		host:   pattern,
+		parent: engine.RouterGroup,
+		engine: engine,
+	}
+	engine.router.mu.Lock()
+	engine.groups = append(engine.groups, group)
+	engine.router.mu.Unlock()
+	return group
+}
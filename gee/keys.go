@@ -0,0 +1,184 @@
+package gee
+
+import (
+	"fmt"
+	"time"
+)
+
+// 请求范围内的键值存储
+// 中间件通过 c.Set 保存数据（例如认证后的用户），之后的中间件和处理函数通过 c.Get 或类型化的 GetXxx 读取。
+// 存储只在第一次 Set 时创建，并由互斥锁保护，可以在处理函数启动的 goroutine 中并发读写（配合 c.Copy 使用时，
+// 副本持有一份独立的拷贝）。保存的值同样可以通过 context.Context 的 Value 方法读取，
+// 在 HTML 模板中可以直接通过 {{.key}} 访问，见 Context.HTML。
+
+// Set 为当前请求保存键值对
+func (c *Context) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string]interface{})
+	}
+	c.keys[key] = value
+}
+
+// Get 返回 key 对应的值，不存在时 exists 为 false
+func (c *Context) Get(key string) (value interface{}, exists bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, exists = c.keys[key]
+	return
+}
+
+// MustGet 返回 key 对应的值，不存在时直接 panic
+func (c *Context) MustGet(key string) interface{} {
+	if value, exists := c.Get(key); exists {
+		return value
+	}
+	panic("gee: key \"" + key + "\" does not exist")
+}
+
+// GetString 返回 key 对应的 string，不存在或类型不符时返回零值，下面的 GetXxx 与之相同
+func (c *Context) GetString(key string) (s string) {
+	if value, ok := c.Get(key); ok {
+		s, _ = value.(string)
+	}
+	return
+}
+
+// GetBool 返回 key 对应的 bool
+func (c *Context) GetBool(key string) (b bool) {
+	if value, ok := c.Get(key); ok {
+		b, _ = value.(bool)
+	}
+	return
+}
+
+// GetInt 返回 key 对应的 int
+func (c *Context) GetInt(key string) (i int) {
+	if value, ok := c.Get(key); ok {
+		i, _ = value.(int)
+	}
+	return
+}
+
+// GetInt64 返回 key 对应的 int64
+func (c *Context) GetInt64(key string) (i int64) {
+	if value, ok := c.Get(key); ok {
+		i, _ = value.(int64)
+	}
+	return
+}
+
+// GetUint 返回 key 对应的 uint
+func (c *Context) GetUint(key string) (u uint) {
+	if value, ok := c.Get(key); ok {
+		u, _ = value.(uint)
+	}
+	return
+}
+
+// GetFloat64 返回 key 对应的 float64
+func (c *Context) GetFloat64(key string) (f float64) {
+	if value, ok := c.Get(key); ok {
+		f, _ = value.(float64)
+	}
+	return
+}
+
+// GetTime 返回 key 对应的 time.Time
+func (c *Context) GetTime(key string) (t time.Time) {
+	if value, ok := c.Get(key); ok {
+		t, _ = value.(time.Time)
+	}
+	return
+}
+
+// GetDuration 返回 key 对应的 time.Duration
+func (c *Context) GetDuration(key string) (d time.Duration) {
+	if value, ok := c.Get(key); ok {
+		d, _ = value.(time.Duration)
+	}
+	return
+}
+
+// GetStringSlice 返回 key 对应的 []string
+func (c *Context) GetStringSlice(key string) (ss []string) {
+	if value, ok := c.Get(key); ok {
+		ss, _ = value.([]string)
+	}
+	return
+}
+
+// GetStringMap 返回 key 对应的 map[string]interface{}，值为 H 时同样可以读取
+func (c *Context) GetStringMap(key string) (m map[string]interface{}) {
+	if value, ok := c.Get(key); ok {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			m = v
+		case H:
+			m = v
+		}
+	}
+	return
+}
+
+// Keys 返回当前请求保存的全部键值对的拷贝
+func (c *Context) Keys() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make(map[string]interface{}, len(c.keys))
+	for k, v := range c.keys {
+		keys[k] = v
+	}
+	return keys
+}
+
+// Key 类型化的键，在编译期保证读写的值类型一致，例如
+//
+//	var UserKey = gee.NewKey[*User]("user")
+//
+//	UserKey.Set(c, user)         // 在认证中间件中
+//	user, ok := UserKey.Get(c)   // 在处理函数中，user 的类型为 *User
+//
+// 值与 c.Set(name, value) 保存在同一个存储中，因此 c.Get("user")、模板中的 {{.user}} 同样可以读取
+type Key[T any] struct {
+	name string
+}
+
+// NewKey 创建名为 name 的类型化键
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// Name 返回键的名字
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// Set 为请求 c 保存 value
+func (k Key[T]) Set(c *Context, value T) {
+	c.Set(k.name, value)
+}
+
+// Get 返回请求 c 中保存的值，不存在或者值的类型不是 T 时 ok 为 false
+func (k Key[T]) Get(c *Context) (value T, ok bool) {
+	if v, exists := c.Get(k.name); exists {
+		value, ok = v.(T)
+	}
+	return
+}
+
+// MustGet 返回请求 c 中保存的值，不存在或者值的类型不是 T 时直接 panic
+func (k Key[T]) MustGet(c *Context) T {
+	v := c.MustGet(k.name)
+	value, ok := v.(T)
+	if !ok {
+		panic(fmt.Sprintf("gee: key %q holds %T, not %T", k.name, v, value))
+	}
+	return value
+}
+
+// contextKey 返回类型化的键在存储中的名字，Context.Value 通过它识别 Key[T]
+func (k Key[T]) contextKey() string {
+	return k.name
+}
@@ -0,0 +1,99 @@
+package gee
+
+import (
+	"path"
+	"strings"
+)
+
+// RESTful 资源路由
+// RouterGroup.Resource 按控制器实现了哪些方法注册对应的路由，以 /users 为例：
+//
+//	Indexer  GET    /users
+//	Creator  POST   /users
+//	Shower   GET    /users/:user_id
+//	Updater  PUT    /users/:user_id 和 PATCH /users/:user_id
+//	Deleter  DELETE /users/:user_id
+//
+// 资源 ID 的参数名是资源名的单数形式加上 _id，同一个资源在自己的路由和嵌套路由中使用同一个参数名，
+// 因此注册的路由模式就是实际匹配的模式，可以直接用于 Engine.URL。
+// 控制器只需要实现其中的一部分方法，没有实现的操作不注册路由。
+
+// Indexer 列出资源，对应 GET /resources
+type Indexer interface {
+	Index(c *Context)
+}
+
+// Creator 创建资源，对应 POST /resources
+type Creator interface {
+	Create(c *Context)
+}
+
+// Shower 读取单个资源，对应 GET /resources/:resource_id
+type Shower interface {
+	Show(c *Context)
+}
+
+// Updater 修改单个资源，对应 PUT 和 PATCH /resources/:resource_id
+type Updater interface {
+	Update(c *Context)
+}
+
+// Deleter 删除单个资源，对应 DELETE /resources/:resource_id
+type Deleter interface {
+	Delete(c *Context)
+}
+
+// Resource 为 controller 实现的 Indexer、Creator、Shower、Updater、Deleter 注册资源路由，
+// middlewares 作用于该资源的全部路由（包括嵌套资源）。controller 一个方法都没有实现时直接 panic。
+//
+// 返回资源成员（prefix/:user_id）的分组，可以在上面注册自定义的成员操作或者嵌套资源：
+//
+//	users := r.Resource("/users", &UserController{})
+//	users.GET("/profile", profile)              // GET /users/:user_id/profile
+//	users.Resource("/posts", &PostController{}) // GET /users/:user_id/posts/:post_id ...
+//
+// 资源 ID 的参数名由 prefix 的最后一段按单数形式得到，例如 /users 为 user_id，/categories 为 category_id。
+// 注册的路由与其他路由一样可以通过 Engine.Routes 查看
+func (group *RouterGroup) Resource(prefix string, controller interface{}, middlewares ...HandlerFunc) *RouterGroup {
+	collection := group.Group(prefix, middlewares...)
+	registered := false
+	if h, ok := controller.(Indexer); ok {
+		collection.GET("", h.Index)
+		registered = true
+	}
+	if h, ok := controller.(Creator); ok {
+		collection.POST("", h.Create)
+		registered = true
+	}
+	member := "/:" + singular(path.Base(prefix)) + "_id"
+	if h, ok := controller.(Shower); ok {
+		collection.GET(member, h.Show)
+		registered = true
+	}
+	if h, ok := controller.(Updater); ok {
+		collection.PUT(member, h.Update)
+		collection.PATCH(member, h.Update)
+		registered = true
+	}
+	if h, ok := controller.(Deleter); ok {
+		collection.DELETE(member, h.Delete)
+		registered = true
+	}
+	if !registered {
+		panic("gee: resource '" + collection.prefix + "' controller implements none of Index, Create, Show, Update, Delete")
+	}
+	return collection.Group(member)
+}
+
+// singular 返回英文资源名的单数形式，只处理常见的 -ies、-s 结尾
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "ss"):
+		return name
+	case strings.HasSuffix(name, "s") && len(name) > 1:
+		return name[:len(name)-1]
+	}
+	return name
+}
@@ -2,22 +2,68 @@ package gee
 
 import (
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+// mountMethod Mount 注册路由时使用的请求方法，这些路由放在单独的路由树中，
+// 请求方法对应的路由树中没有匹配时，不区分请求方法地回退到这棵树，因此 PROPFIND 等自定义方法同样能到达挂载的 http.Handler
+const mountMethod = "*"
+
 // router route struct
+// 路由表可以在服务运行期间修改：注册、删除路由以及 Use 持有 mu 的写锁，
+// 处理请求时只在查找路由、选出处理函数链期间持有读锁，执行处理函数链时不持有锁
 type router struct {
-	roots    map[string]*node       //路由树，以不同的 HTTP 方法作为键（key），对应的值是路由树的根节点
-	handlers map[string]HandlerFunc //处理函数（handler）的字典，以路径作为键，对应的值是处理该路径的处理函数
+	mu        sync.RWMutex
+	roots     map[string]*node  //路由树，以不同的 HTTP 方法作为键（key），对应的值是路由树的根节点
+	handlers  map[string]*Route //路由的字典，以 请求方法-路由模式 作为键，对应的值是该路由及其处理函数链
+	routes    []*Route          //按注册顺序保存的全部路由，删除路由后用于重建路由树
+	primaries map[string]*Route //占据路由树节点的路由，以 请求方法-Host模式+路由模式 作为键，其他版本挂在它的 variants 上
+	names     map[string]*Route //命名路由的字典，以路由名作为键，用于反向生成 URL
+	hosts     []*hostRoutes     //绑定了 Host 模式的路由树，按注册顺序匹配，都不匹配时使用 roots
+	maxParams int               //所有路由中参数个数的最大值（含 Host 参数），用于预先分配 Params 的容量
+}
+
+// Route 一条已注册的路由，由 GET、POST 等注册方法返回，可以通过 Name 为路由命名。
+// chain 是注册时就合并好的 所属分组（含各级父分组）的中间件 + 路由自己的 handlers，
+// 请求到来时直接使用，不需要再逐个遍历分组；分组之后再调用 Use 时，会通过 router.rebuild 重新合并
+type Route struct {
+	method   string        // 请求方法
+	pattern  string        // 注册时的完整路由模式
+	host     string        // 路由绑定的 Host 模式，不绑定时为空
+	version  string        // 路由绑定的 API 版本，不绑定时为空
+	variants []*Route      // 同一请求方法、Host 和路由模式的全部版本（含自身），只有一个版本时为 nil
+	name     string        // 路由名，未命名时为空
+	group    *RouterGroup  // 路由所属的分组，直接通过 router.addRoute 注册时为 nil
+	handlers []HandlerFunc // 路由自己的处理函数链，最后一个是路由的处理函数
+	chain    []HandlerFunc // 实际执行的处理函数链
+	router   *router       // 路由所在的 router，用于登记路由名
+}
+
+// Name 为路由命名，之后可以通过 Engine.URL 或模板函数 url 按名字生成该路由的 URL。
+// 同一个名字只能对应一个路由模式，重复使用时直接 panic；不同请求方法的同一路由模式可以共用一个名字
+func (rt *Route) Name(name string) *Route {
+	rt.router.mu.Lock()
+	defer rt.router.mu.Unlock()
+	if existing, ok := rt.router.names[name]; ok && existing.pattern != rt.pattern {
+		panic("gee: route name '" + name + "' is already used by route '" + existing.pattern + "'")
+	}
+	rt.name = name
+	rt.router.names[name] = rt
+	return rt
 }
 
 // NewRouter Create New Router object
-// 使用 roots 来存储每种请求方式的Trie 树根节点
+// 使用 roots 来存储每种请求方式的Radix 树根节点
 // 使用 handlers 存储每种请求方式的 HandlerFunc
 func NewRouter() *router {
 	return &router{
-		roots:    make(map[string]*node),
-		handlers: make(map[string]HandlerFunc),
+		roots:     make(map[string]*node),
+		handlers:  make(map[string]*Route),
+		names:     make(map[string]*Route),
+		primaries: make(map[string]*Route),
 	}
 }
 
@@ -45,72 +91,511 @@ func parsePattern(pattern string) []string {
 	return parts
 }
 
+// checkCatchAll 检查 *catchAll 之后是否还有其他路由段。parsePattern 遇到 * 就会停止解析，
+// 如果不提前检查，/static/*filepath/edit 会被悄悄截断成 /static/*filepath
+func checkCatchAll(pattern string) {
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if part == "" || part[0] != '*' {
+			continue
+		}
+		for _, rest := range parts[i+1:] {
+			if rest != "" {
+				panic("gee: catch-all '" + part + "' in route '" + pattern + "' must be the last segment")
+			}
+		}
+	}
+}
+
+// cleanPattern 把路由模式规范化为 Radix 树中保存的形式：去掉空的路由段，但保留末尾的 /，
+// 因此 /v1//hello 与 /v1/hello 是同一个路由，而 /v1/hello/ 与 /v1/hello 是两个可以区分的路由
+func cleanPattern(pattern string) string {
+	parts := parsePattern(pattern)
+	path := "/" + strings.Join(parts, "/")
+	if len(parts) > 0 && parts[len(parts)-1][0] != '*' && strings.HasSuffix(pattern, "/") {
+		path += "/"
+	}
+	return path
+}
+
 // addRoute 在路由中注册一个路由和其对应的处理函数，我们需要完成以下步骤：
 //
-//	解析路由模式，获取其中的所有部分。
+//	规范化路由模式，并解析其中的参数个数。
 //	以请求方法作为键，将节点树添加到 roots 映射中。
 //	使用节点树将路由模式添加到路由树中。
 //	以 请求方法 + 路由模式 作为键，将处理函数添加到处理函数映射中
 //
-// 我们首先通过 parsePattern 函数解析出路由模式中的所有部分，然后将请求方法作为键，
+// 我们首先通过 cleanPattern 函数规范化路由模式，然后将请求方法作为键，
 // 将节点树添加到 roots 映射中。如果 roots 映射尚不存在，则创建一个新的 roots 映射。
-// 我们在 handlers 映射中存储处理函数，以 请求方法 + 路由模式 作为键。
-// 现在我们可以通过以下代码调用 addRoute 方法，将路由和其处理函数添加到路由树中
-func (r *router) addRoute(method string, pattern string, handler HandlerFunc) {
-	parts := parsePattern(pattern)
+// 我们在 handlers 映射中存储路由及其处理函数链，以 请求方法 + 路由模式 作为键。
+// 注册前会检查路由是否合法：*catchAll 只能出现在最后一段，同一个 method-pattern 不能重复注册，违反时直接 panic。
+// 带有可省略参数的路由会展开成多种形式分别插入路由树，它们共用同一个 Route，展开后与已有路由重复同样会 panic
+func (r *router) addRoute(method string, pattern string, handlers ...HandlerFunc) *Route {
+	return r.addGroupRoute(nil, method, pattern, handlers...)
+}
 
-	key := Concat(method, "-", pattern)
+// addGroupRoute 与 addRoute 相同，但路由属于分组 group：注册到分组绑定的 Host 模式对应的路由树中
+// （分组不绑定 Host 时为默认的路由树），并在持有写锁期间合并好分组的中间件，group 为 nil 时等价于 addRoute。
+// 绑定 Host 的路由以 请求方法-Host模式+路由模式 作为键，与不绑定 Host 的同名路由互不冲突；
+// 绑定 API 版本的路由在键的末尾加上 @版本，同一路由模式可以为不同的版本各注册一次
+func (r *router) addGroupRoute(group *RouterGroup, method string, pattern string, handlers ...HandlerFunc) *Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rt := &Route{method: method, pattern: pattern, group: group, handlers: handlers, chain: handlers, router: r}
+	if group != nil {
+		rt.host, rt.version = group.host, group.version
+		rt.chain = group.combineHandlers(handlers...)
+	}
+	checkCatchAll(pattern)
+
+	key := rt.key()
 	DPrintf("[Router]Key:%s\n", key)
-	_, ok := r.roots[method]
+	if _, ok := r.handlers[key]; ok {
+		panic("gee: route '" + method + " " + rt.host + pattern + "' is already registered" + versionSuffix(rt.version))
+	}
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				// 可省略参数展开的多个变体是逐个插入的，后面的变体冲突时前面的已经在路由树中，
+				// 用已注册的路由重建路由树，撤销这些变体后再 panic
+				r.rebuildTrees()
+				panic(err)
+			}
+		}()
+		r.insertRoute(rt)
+	}()
+	r.handlers[key] = rt
+	r.routes = append(r.routes, rt)
+	return rt
+}
+
+// key 返回路由在 handlers 中的键
+func (rt *Route) key() string {
+	if rt.version != "" {
+		return Concat(rt.method, "-", rt.host, rt.pattern, "@", rt.version)
+	}
+	return Concat(rt.method, "-", rt.host, rt.pattern)
+}
+
+// insertRoute 把路由插入对应的路由树，调用方需要持有写锁。
+// 同一请求方法、Host 和路由模式的多个版本共用路由树中的一个节点：先注册的路由占据节点，之后的版本挂在它的 variants 上
+func (r *router) insertRoute(rt *Route) {
+	base := Concat(rt.method, "-", rt.host, rt.pattern)
+	if primary, ok := r.primaries[base]; ok {
+		if primary.variants == nil {
+			primary.variants = []*Route{primary}
+		}
+		primary.variants = append(primary.variants, rt)
+		return
+	}
+	roots, hostParams := r.hostRoots(rt.host)
+	if roots[rt.method] == nil {
+		roots[rt.method] = &node{}
+	}
+	path := cleanPattern(rt.pattern)
+	for _, variant := range expandOptional(path) {
+		roots[rt.method].insert(rt.pattern, variant, rt)
+	}
+	r.primaries[base] = rt
+	if n := hostParams + countParams(path); n > r.maxParams {
+		r.maxParams = n
+	}
+}
+
+// countParams 返回规范化后的路由模式 path 中参数和通配符的个数
+func countParams(path string) int {
+	n := 0
+	for _, seg := range parseSegments(path) {
+		if seg.kind != staticKind {
+			n++
+		}
+	}
+	return n
+}
+
+// removeRoute 删除 method-pattern 对应的路由，pattern 以 Host 模式开头时删除绑定该 Host 的路由，
+// 例如 :tenant.example.com/users；以 @版本 结尾时删除绑定该 API 版本的路由，例如 /users@2。路由不存在时返回 false。
+// Radix 树中的节点会被合并和拆分，直接摘除节点比较复杂，因此删除后按注册顺序用剩余的路由重建全部路由树
+func (r *router) removeRoute(method string, pattern string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := Concat(method, "-", pattern)
+	rt, ok := r.handlers[key]
 	if !ok {
-		r.roots[method] = &node{}
+		return false
+	}
+	delete(r.handlers, key)
+	for i, route := range r.routes {
+		if route == rt {
+			r.routes = append(r.routes[:i:i], r.routes[i+1:]...)
+			break
+		}
+	}
+	if rt.name != "" && r.names[rt.name] == rt {
+		delete(r.names, rt.name)
+		for _, route := range r.routes {
+			if route.name == rt.name {
+				r.names[rt.name] = route
+				break
+			}
+		}
+	}
+	r.rebuildTrees()
+	return true
+}
+
+// rebuildTrees 按注册顺序用 r.routes 中的路由重建全部路由树，调用方需要持有写锁
+func (r *router) rebuildTrees() {
+	r.roots = make(map[string]*node)
+	for _, h := range r.hosts {
+		h.roots = make(map[string]*node)
+	}
+	r.primaries = make(map[string]*Route)
+	r.maxParams = 0
+	for _, route := range r.routes {
+		route.variants = nil
+		r.insertRoute(route)
 	}
-	r.roots[method].insert(pattern, parts, 0)
-	r.handlers[key] = handler
 }
 
-func (r *router) getRoute(method string, path string) (*node, map[string]string) {
-	searchParts := parsePattern(path)
-	params := make(map[string]string)
-	root, ok := r.roots[method]
+// hostRoots 返回 Host 模式 host 对应的路由树及其中的参数个数，不存在时新建；host 为空时返回默认的路由树
+func (r *router) hostRoots(host string) (map[string]*node, int) {
+	if host == "" {
+		return r.roots, 0
+	}
+	for _, h := range r.hosts {
+		if h.host.pattern == host {
+			return h.roots, h.host.params
+		}
+	}
+	h := &hostRoutes{host: parseHostPattern(host), roots: make(map[string]*node)}
+	r.hosts = append(r.hosts, h)
+	return h.roots, h.host.params
+}
 
+// rebuild 重新合并所有路由的处理函数链，在分组的中间件发生变化后调用，调用方需要持有写锁
+func (r *router) rebuild() {
+	for _, rt := range r.handlers {
+		if rt.group != nil {
+			rt.chain = rt.group.combineHandlers(rt.handlers...)
+		}
+	}
+}
+
+// search 在 method 对应的路由树中查找已经规范化的 path，匹配到的参数追加到 ps 中。
+// ps 由调用方预先分配（容量为 maxParams），查找本身不分配内存
+func (r *router) search(method string, path string, ps *Params) *node {
+	return searchIn(r.roots, method, path, ps)
+}
+
+// searchIn 与 search 相同，但在给定的路由树 roots 中查找
+func searchIn(roots map[string]*node, method string, path string, ps *Params) *node {
+	root, ok := roots[method]
 	if !ok {
+		return nil
+	}
+	return root.search(path, ps)
+}
+
+// getRoute 查找路由并返回匹配到的节点和参数，没有匹配时返回 nil
+func (r *router) getRoute(method string, path string) (*node, Params) {
+	params := make(Params, 0, r.maxParams)
+	n := r.search(method, cleanPath(path), &params)
+	if n == nil {
 		return nil, nil
 	}
+	return n, params
+}
 
-	n := root.search(searchParts, 0)
+// lookup 按请求的 host 查找路由：依次尝试 Host 模式匹配的路由树，Host 参数先于路径参数追加到 ps 中；
+// 都没有匹配到路由（或需要重定向的规范路径）时，回退到不绑定 Host 的默认路由树。返回值与 find 相同
+func (r *router) lookup(engine *Engine, host string, method string, path string, ps *Params) (*node, string) {
+	if len(r.hosts) > 0 {
+		host = stripPort(host)
+		for _, h := range r.hosts {
+			if !h.host.match(host, ps) {
+				continue
+			}
+			if n, redirect := r.find(engine, h.roots, method, path, ps); n != nil || redirect != "" {
+				return n, redirect
+			}
+			*ps = (*ps)[:0]
+		}
+	}
+	return r.find(engine, r.roots, method, path, ps)
+}
 
-	if n != nil {
-		parts := parsePattern(n.pattern)
-		for index, part := range parts {
-			if part[0] == ':' {
-				params[part[1:]] = searchParts[index]
+// find 按 engine 的配置在路由树 roots 中查找请求路径 path，返回匹配到的节点，或者需要重定向到的规范路径：
+//
+//	先规范化 path（合并多余的 /，处理 . 和 ..）后精确查找；
+//	没有匹配时，非 StrictSlash 模式下尝试增减末尾的 / 再查找一次；
+//	仍然没有匹配且开启了 RedirectFixedPath 时，不区分大小写地查找一次。
+//
+// 开启 RedirectTrailingSlash 时，只有末尾 / 不同的匹配结果会以重定向的形式返回；
+// 开启 RedirectFixedPath 时，经过规范化或大小写修正才匹配到的结果会以重定向的形式返回。
+func (r *router) find(engine *Engine, roots map[string]*node, method string, path string, ps *Params) (*node, string) {
+	mark := len(*ps)
+	clean := cleanPath(path)
+	fixed := clean != path && engine.RedirectFixedPath
+	if n := searchIn(roots, method, clean, ps); n != nil {
+		if fixed {
+			*ps = (*ps)[:mark]
+			return nil, clean
+		}
+		return n, ""
+	}
+
+	trySlash := !engine.StrictSlash || engine.RedirectTrailingSlash
+	if trySlash && clean != "/" {
+		alt := toggleTrailingSlash(clean)
+		if n := searchIn(roots, method, alt, ps); n != nil {
+			if fixed || engine.RedirectTrailingSlash {
+				*ps = (*ps)[:mark]
+				return nil, alt
 			}
-			if part[0] == '*' && len(part) > 1 {
-				params[part[1:]] = strings.Join(searchParts[index:], "/")
-				break
+			return n, ""
+		}
+	}
+
+	if engine.RedirectFixedPath {
+		if root, ok := roots[method]; ok {
+			if buf, ok := root.searchFold(clean, nil); ok {
+				return nil, string(buf)
+			}
+			if trySlash && clean != "/" {
+				if buf, ok := root.searchFold(toggleTrailingSlash(clean), nil); ok {
+					return nil, string(buf)
+				}
 			}
 		}
-		return n, params
 	}
+	return nil, ""
+}
 
-	return nil, nil
+// allowed 在 host 匹配的全部路由树中查找 path，返回该路径允许的请求方法，按字母序排列。
+// 开启 HandleHEAD 或 HandleOPTIONS 时，会自动应答的 HEAD、OPTIONS 也包含在内；没有任何方法能匹配时返回 nil
+func (r *router) allowed(engine *Engine, host string, path string) []string {
+	var methods []string
+	has := func(method string) bool {
+		for _, m := range methods {
+			if m == method {
+				return true
+			}
+		}
+		return false
+	}
+	ps := make(Params, 0, r.maxParams)
+	collect := func(roots map[string]*node) {
+		for method := range roots {
+			if method == mountMethod {
+				continue
+			}
+			ps = ps[:0]
+			if n, _ := r.find(engine, roots, method, path, &ps); n != nil && !has(method) {
+				methods = append(methods, method)
+			}
+		}
+	}
+	host = stripPort(host)
+	for _, h := range r.hosts {
+		if h.host.match(host, &ps) {
+			collect(h.roots)
+		}
+	}
+	collect(r.roots)
+	if len(methods) == 0 {
+		return nil
+	}
+	if engine.HandleHEAD && has(http.MethodGet) && !has(http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	if engine.HandleOPTIONS && !has(http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
+	sort.Strings(methods)
+	return methods
 }
 
 // handle  HTTP request  process
+// 开启 UseRawPath 且请求路径中含有转义字符时，在转义形式的 URL.RawPath 上查找路由，
+// 这样 /files/a%2Fb 中的 a%2Fb 是一个路由段，开启 UnescapePathValues 时再逐个解码匹配到的参数值。
 // 在调用匹配到的handler前，将解析出来的路由参数赋值给了c.Params。
 // 这样就能够在handler中，通过Context对象访问到具体的值了。
+// 匹配到路由时直接执行注册时合并好的处理函数链；没有匹配到时，执行请求路径所属分组（见 missGroup）的中间件和相应的兜底处理函数。
+// 没有匹配到路由时依次尝试：HEAD 回退到 GET 路由、Mount 挂载的路由、自动应答 OPTIONS、405，最后按 404 处理；
+// 匹配到的路由没有请求的 API 版本、也没有可以回退的版本时（n 不为 nil 而 rt 为 nil），直接按 404 处理。
+// find 返回规范路径时，GET、HEAD 请求以 301、其他请求以 308 重定向过去，308 会要求客户端保持原来的请求方法和请求体。
 func (r *router) handle(c *Context) {
-	n, params := r.getRoute(c.Method, c.Path)
+	r.mu.RLock()
+	if cap(c.Params) < r.maxParams {
+		c.Params = make(Params, 0, r.maxParams)
+	} else {
+		c.Params = c.Params[:0]
+	}
+	path, unescape := c.Path, false
+	if c.engine.UseRawPath && c.Req.URL.RawPath != "" && c.Path == c.Req.URL.Path {
+		path, unescape = c.Req.URL.RawPath, c.engine.UnescapePathValues
+	}
+	n, redirect := r.lookup(c.engine, c.Req.Host, c.Method, path, &c.Params)
+	if n == nil && redirect == "" && c.Method == http.MethodHead && c.engine.HandleHEAD {
+		if n, redirect = r.lookup(c.engine, c.Req.Host, http.MethodGet, path, &c.Params); n != nil {
+			w := &headResponseWriter{ResponseWriter: c.Writer}
+			c.Writer = w
+			defer w.flush()
+		}
+	}
+	if n == nil && redirect == "" {
+		n, redirect = r.lookup(c.engine, c.Req.Host, mountMethod, path, &c.Params)
+	}
+
+	var rt *Route
 	if n != nil {
-		c.Params = params
-		key := Concat(c.Method, "-", n.pattern)
-		//r.handlers[key](c)
-		c.handlers = append(c.handlers, r.handlers[key])
+		rt = n.route.selectVersion(c)
+	}
+
+	if rt != nil {
+		if unescape {
+			c.Params.unescape()
+		}
+		c.handlers = rt.chain
+	} else if group := r.missGroup(c.engine, c.Req.Host, path); redirect != "" {
+		c.handlers = group.combineHandlers(redirectHandler(redirect))
+	} else if methods := r.allowed(c.engine, c.Req.Host, path); n == nil && methods != nil && c.Method == http.MethodOptions && c.engine.HandleOPTIONS {
+		c.SetHeader("Allow", strings.Join(methods, ", "))
+		c.handlers = group.combineHandlers(func(c *Context) {
+			c.Status(http.StatusNoContent)
+		})
+	} else if n == nil && methods != nil && c.engine.HandleMethodNotAllowed {
+		c.SetHeader("Allow", strings.Join(methods, ", "))
+		if noMethod := c.engine.noMethod; len(noMethod) > 0 {
+			c.handlers = group.combineHandlers(noMethod...)
+		} else {
+			c.handlers = group.combineHandlers(func(c *Context) {
+				c.String(http.StatusMethodNotAllowed, "405 METHOD NOT ALLOWED: %s\n", c.Path)
+			})
+		}
+	} else if noRoute := group.noRouteChain(); noRoute != nil {
+		c.handlers = group.combineHandlers(noRoute...)
 	} else {
-		c.handlers = append(c.handlers, func(c *Context) {
+		c.handlers = group.combineHandlers(func(c *Context) {
 			c.String(http.StatusNotFound, "404 NOT FOUND: %s\n", c.Path)
 		})
 	}
+	r.mu.RUnlock()
 	c.Next()
 }
+
+// missGroup 返回没有匹配到路由时请求路径所属的分组：在 Host 匹配的分组中，选出前缀按路由段与 path 匹配、
+// 且前缀最长的分组，例如 /api/users/1 属于 /api 分组，而不属于 /ap 分组；前缀长度相同时优先选择绑定 Host 的分组。
+// 没有其他分组匹配时返回 Engine 自身的分组。只在没有匹配到路由时调用，不追求零分配
+func (r *router) missGroup(engine *Engine, host string, path string) *RouterGroup {
+	host = stripPort(host)
+	best, depth := engine.RouterGroup, -1
+	var ps Params
+	for _, group := range engine.groups {
+		d, ok := prefixDepth(group.prefix, path)
+		if !ok || d < depth || d == depth && (group.host == "" || best.host != "") {
+			continue
+		}
+		if group.host != "" && !r.matchHost(group.host, host, &ps) {
+			continue
+		}
+		best, depth = group, d
+	}
+	return best
+}
+
+// matchHost 判断 host 是否符合 Host 模式 pattern，匹配到的参数追加到 ps 中
+func (r *router) matchHost(pattern string, host string, ps *Params) bool {
+	for _, h := range r.hosts {
+		if h.host.pattern == pattern {
+			return h.host.match(host, ps)
+		}
+	}
+	// 分组还没有注册任何路由时，r.hosts 中没有它的 Host 模式
+	return parseHostPattern(pattern).match(host, ps)
+}
+
+// prefixDepth 判断分组前缀 prefix 是否按路由段匹配 path 的开头，并返回 prefix 中路由段的个数。
+// prefix 中的 :param 段匹配任意一段，*catchAll 段匹配剩余的全部路径
+func prefixDepth(prefix string, path string) (int, bool) {
+	depth := 0
+	for {
+		prefix = strings.TrimLeft(prefix, "/")
+		path = strings.TrimLeft(path, "/")
+		if prefix == "" {
+			return depth, true
+		}
+		if prefix[0] == '*' {
+			return depth + 1, true
+		}
+		seg, segEnd := prefix, len(prefix)
+		if i := strings.IndexByte(prefix, '/'); i >= 0 {
+			seg, segEnd = prefix[:i], i
+		}
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		if path == "" || seg[0] != ':' && seg != path[:end] {
+			return 0, false
+		}
+		prefix, path = prefix[segEnd:], path[end:]
+		depth++
+	}
+}
+
+// redirectHandler 返回重定向到规范路径 location 的处理函数，请求中的查询参数原样保留
+func redirectHandler(location string) HandlerFunc {
+	return func(c *Context) {
+		code := http.StatusPermanentRedirect
+		if c.Method == http.MethodGet || c.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		if c.Req.URL.RawQuery != "" {
+			location += "?" + c.Req.URL.RawQuery
+		}
+		c.Redirect(code, location)
+	}
+}
+
+// headResponseWriter 用于 HEAD 请求回退到 GET 路由时包装 http.ResponseWriter：
+// 丢弃写入的响应体，只统计其长度，并推迟写出状态码，以便在处理结束后补上 Content-Length
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+// WriteHeader 只记录第一次设置的状态码，真正的写出推迟到 flush
+func (w *headResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+// Write 丢弃响应体，只累加长度
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	w.size += len(b)
+	return len(b), nil
+}
+
+// Flush 实现 http.Flusher，使流式输出的处理函数在 HEAD 请求下行为一致。
+// 响应头要等到处理结束后补上 Content-Length 才能写出，因此这里什么也不做
+func (w *headResponseWriter) Flush() {}
+
+// Unwrap 返回被包装的 http.ResponseWriter，供 http.ResponseController 访问底层连接
+func (w *headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush 在处理函数链执行完毕后写出响应头，处理函数没有设置 Content-Length 时使用统计到的响应体长度
+func (w *headResponseWriter) flush() {
+	if w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(w.size))
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
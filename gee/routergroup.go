@@ -4,29 +4,41 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
+	"strings"
 )
 
 // RouterGroup 用于实现路由分组和中间件功能
 type RouterGroup struct {
 	prefix        string             // 前缀，用于给分组内的所有路由统一添加前缀
+	host          string             // 分组绑定的 Host 模式，为空时不限制 Host
+	version       string             // 分组绑定的 API 版本，为空时不限制版本
 	middlewares   []HandlerFunc      // 中间件列表，用于在路由处理函数执行前或执行后进行操作
 	parent        *RouterGroup       // 父级分组，支持嵌套分组
 	htmlTemplates *template.Template // HTML 模板，用于渲染 HTML 页面
 	funcMap       template.FuncMap   // 函数映射，用于在 HTML 模板中使用自定义函数
 	engine        *Engine            // Engine 实例，用于所有分组共享 Engine 实例的功能
+	noRoute       []HandlerFunc      // 分组内没有匹配到路由时执行的处理函数链
 }
 
 // Group is defined to create a new RouterGroup
 // remember all groups share the same Engine instance
-func (group *RouterGroup) Group(prefix string) *RouterGroup {
+// middlewares 会直接作为新分组的中间件，等价于创建后再调用 Use
+func (group *RouterGroup) Group(prefix string, middlewares ...HandlerFunc) *RouterGroup {
 	engine := group.engine
 	newGroup := &RouterGroup{
-		prefix: group.prefix + prefix,
-		parent: group,
-		engine: engine,
+		prefix:      group.prefix + prefix,
+		host:        group.host,
+		version:     group.version,
+		middlewares: middlewares,
+		parent:      group,
+		engine:      engine,
 	}
+	engine.router.mu.Lock()
 	engine.groups = append(engine.groups, newGroup)
+	engine.router.mu.Unlock()
 	return newGroup
 }
 
@@ -34,27 +46,147 @@ func (group *RouterGroup) Group(prefix string) *RouterGroup {
 // 可以仔细观察下addRoute函数，调用了group.engine.router.addRoute来实现了路由的映射。
 // 由于Engine从某种意义上继承了RouterGroup的所有属性和方法，因为 (*Engine).engine 是指向自己的。
 // 这样实现，我们既可以像原来一样添加路由，也可以通过分组添加路由。
-func (group *RouterGroup) addRoute(method string, comp string, handler HandlerFunc) {
+// 注册时就把分组的中间件和 handlers 合并成处理函数链保存在路由上。
+// handlers 是路由自己的处理函数链，前面的可以是只作用于这一条路由的中间件，最后一个是真正的处理函数
+func (group *RouterGroup) addRoute(method string, comp string, handlers ...HandlerFunc) *Route {
 	pattern := group.prefix + comp
-	log.Printf("Route %4s - %s", method, pattern)
-	group.engine.router.addRoute(method, pattern, handler)
+	if len(handlers) == 0 {
+		panic("gee: route '" + method + " " + pattern + "' must have at least one handler")
+	}
+	log.Printf("Route %4s - %s%s%s", method, group.host, pattern, versionSuffix(group.version))
+	return group.engine.router.addGroupRoute(group, method, pattern, handlers...)
+}
+
+// middlewareChain 返回作用在该分组上的全部中间件：先是各级父分组的中间件，然后是分组自身的中间件。
+// 中间件按分组的嵌套关系继承，而不是按前缀字符串匹配，因此 /v1 的中间件不会作用到 /v10 下的路由
+func (group *RouterGroup) middlewareChain() []HandlerFunc {
+	if group.parent == nil {
+		return group.middlewares
+	}
+	parent := group.parent.middlewareChain()
+	chain := make([]HandlerFunc, 0, len(parent)+len(group.middlewares))
+	chain = append(chain, parent...)
+	return append(chain, group.middlewares...)
+}
+
+// NoRoute 设置请求路径属于该分组、但没有匹配到任何路由时执行的处理函数链，默认返回 404 NOT FOUND 文本。
+// 子分组没有设置时使用最近的父分组的设置，因此在 Engine 上调用时作为全局的兜底。
+// 与普通路由一样，这些处理函数排在分组（含各级父分组）的中间件之后执行，
+// 因此日志、CORS 等中间件对 404 同样生效，例如
+//
+//	r.NoRoute(func(c *gee.Context) { c.HTML(http.StatusNotFound, "404.tmpl", nil) })
+//	api := r.Group("/api")
+//	api.NoRoute(func(c *gee.Context) { c.JSON(http.StatusNotFound, gee.H{"error": "not found"}) })
+func (group *RouterGroup) NoRoute(handlers ...HandlerFunc) {
+	r := group.engine.router
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	group.noRoute = handlers
+}
+
+// noRouteChain 返回分组或最近的父分组通过 NoRoute 设置的处理函数链，都没有设置时返回 nil
+func (group *RouterGroup) noRouteChain() []HandlerFunc {
+	for g := group; g != nil; g = g.parent {
+		if len(g.noRoute) > 0 {
+			return g.noRoute
+		}
+	}
+	return nil
+}
+
+// combineHandlers 返回 分组中间件 + handlers 组成的新处理函数链，不会修改分组自身的中间件切片
+func (group *RouterGroup) combineHandlers(handlers ...HandlerFunc) []HandlerFunc {
+	middlewares := group.middlewareChain()
+	chain := make([]HandlerFunc, 0, len(middlewares)+len(handlers))
+	chain = append(chain, middlewares...)
+	return append(chain, handlers...)
+}
+
+// anyMethods Any 注册时覆盖的全部标准 HTTP 方法
+var anyMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodHead, http.MethodOptions,
+	http.MethodConnect, http.MethodTrace,
+}
+
+// Handle 使用任意请求方法注册路由，除标准方法外也可以注册 WebDAV 一类的自定义方法，
+// 例如 PROPFIND、MKCOL。method 必须是 RFC 7230 规定的合法 token，否则直接 panic
+func (group *RouterGroup) Handle(method string, pattern string, handlers ...HandlerFunc) *Route {
+	if !validMethod(method) {
+		panic("gee: invalid http method " + strconv.Quote(method))
+	}
+	return group.addRoute(method, pattern, handlers...)
 }
 
 // GET defines the method to add GET request
-func (group *RouterGroup) GET(pattern string, handler HandlerFunc) {
-	group.addRoute("GET", pattern, handler)
+func (group *RouterGroup) GET(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodGet, pattern, handlers...)
 }
 
 // POST defines the method to add POST request
-func (group *RouterGroup) POST(pattern string, handler HandlerFunc) {
-	group.addRoute("POST", pattern, handler)
+func (group *RouterGroup) POST(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodPost, pattern, handlers...)
+}
+
+// PUT defines the method to add PUT request
+func (group *RouterGroup) PUT(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodPut, pattern, handlers...)
+}
+
+// PATCH defines the method to add PATCH request
+func (group *RouterGroup) PATCH(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodPatch, pattern, handlers...)
+}
+
+// DELETE defines the method to add DELETE request
+func (group *RouterGroup) DELETE(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodDelete, pattern, handlers...)
+}
+
+// HEAD defines the method to add HEAD request
+func (group *RouterGroup) HEAD(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodHead, pattern, handlers...)
+}
+
+// OPTIONS defines the method to add OPTIONS request
+func (group *RouterGroup) OPTIONS(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodOptions, pattern, handlers...)
+}
+
+// CONNECT defines the method to add CONNECT request
+func (group *RouterGroup) CONNECT(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodConnect, pattern, handlers...)
+}
+
+// TRACE defines the method to add TRACE request
+func (group *RouterGroup) TRACE(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(http.MethodTrace, pattern, handlers...)
+}
+
+// Any 为 anyMethods 中的每一种请求方法都注册同一条处理函数链，每种方法各自落在自己的路由树上
+func (group *RouterGroup) Any(pattern string, handlers ...HandlerFunc) {
+	for _, method := range anyMethods {
+		group.addRoute(method, pattern, handlers...)
+	}
+}
+
+// Match 为 methods 中列出的请求方法注册同一条处理函数链，方法名的校验规则与 Handle 相同
+func (group *RouterGroup) Match(methods []string, pattern string, handlers ...HandlerFunc) {
+	for _, method := range methods {
+		group.Handle(method, pattern, handlers...)
+	}
 }
 
 // Use 方法用于为该组添加中间件。在 Gin 框架中，中间件是对于 HTTP 请求处理流程的一些拦截器，
 // 能够在请求前或请求后执行一些自定义操作。在该方法中，首先获取到该组中已有的中间件列表，
-// 然后将新传入的中间件列表追加到原有中间件列表后面。这样，该组中所有的路由请求都会按顺序依次执行这些中间件
+// 然后将新传入的中间件列表追加到原有中间件列表后面。这样，该组中所有的路由请求都会按顺序依次执行这些中间件。
+// 路由的处理函数链是在注册时合并好的，因此添加中间件后需要重新合并，先注册的路由同样会执行新的中间件
 func (group *RouterGroup) Use(middlewares ...HandlerFunc) {
+	r := group.engine.router
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	group.middlewares = append(group.middlewares, middlewares...)
+	r.rebuild()
 }
 
 // create static handler
@@ -92,3 +224,66 @@ func (group *RouterGroup) Static(relativePath string, root string) {
 	// Register GET handlers
 	group.GET(urlPattern, handler)
 }
+
+// WrapH 把 http.Handler 包装成 HandlerFunc，可以像普通处理函数一样注册到路由上，例如
+//
+//	r.GET("/metrics", gee.WrapH(promhttp.Handler()))
+func WrapH(h http.Handler) HandlerFunc {
+	return func(c *Context) {
+		h.ServeHTTP(c.Writer, c.Req)
+	}
+}
+
+// WrapF 把 http.HandlerFunc 包装成 HandlerFunc
+func WrapF(f http.HandlerFunc) HandlerFunc {
+	return func(c *Context) {
+		f(c.Writer, c.Req)
+	}
+}
+
+// Mount 把 http.Handler 挂载到分组下的 prefix 路径，prefix 本身以及它下面的全部路径都交给 h 处理，
+// 交给 h 的请求去掉了 prefix 前缀，例如
+//
+//	r.Mount("/debug/pprof", http.HandlerFunc(pprof.Index)) // /debug/pprof/heap 到达 h 时路径为 /heap
+//
+// h 也可以是另一个 *Engine，此时子 Engine 的路由挂载在 prefix 下，请求先经过当前分组（含各级父分组）的中间件，
+// 再交给子 Engine 按它自己的路由和中间件处理。
+// 挂载通过以不区分请求方法的 mountMethod（Engine.Routes 中显示为 *）注册 prefix 和 prefix/*mountpath 两条路由实现，
+// 任何请求方法（包括 PROPFIND、MKCOL 等自定义方法）都会交给 h；
+// 分组的中间件中可以通过 c.Param("mountpath") 读取去掉前缀后的路径（不含开头的 /）。
+// prefix 下为具体请求方法注册的其他路由仍然优先匹配
+func (group *RouterGroup) Mount(prefix string, h http.Handler) {
+	handler := mountHandler(h)
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" {
+		group.addRoute(mountMethod, prefix, handler)
+	}
+	group.addRoute(mountMethod, prefix+"/*mountpath", handler)
+}
+
+// mountHandler 返回把请求去掉挂载前缀后交给 h 处理的处理函数，请求按 http.StripPrefix 的方式浅拷贝，
+// 原始请求保持不变
+func mountHandler(h http.Handler) HandlerFunc {
+	return func(c *Context) {
+		rest := "/" + c.Param("mountpath")
+		req := new(http.Request)
+		*req = *c.Req
+		req.URL = new(url.URL)
+		*req.URL = *c.Req.URL
+		req.URL.Path = rest
+		req.URL.RawPath = ""
+		if c.engine.UseRawPath && !c.engine.UnescapePathValues && c.Req.URL.RawPath != "" {
+			// 路由在转义形式的路径上匹配且没有解码参数值，rest 是转义形式
+			req.URL.RawPath = rest
+			if path, err := url.PathUnescape(rest); err == nil {
+				req.URL.Path = path
+			}
+		} else if raw := c.Req.URL.RawPath; raw != "" && strings.HasSuffix(c.Req.URL.Path, rest) {
+			stripped := strings.TrimSuffix(c.Req.URL.Path, rest)
+			if strings.HasPrefix(raw, stripped) {
+				req.URL.RawPath = raw[len(stripped):]
+			}
+		}
+		h.ServeHTTP(c.Writer, req)
+	}
+}
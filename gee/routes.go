@@ -0,0 +1,63 @@
+package gee
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+)
+
+// RouteInfo 一条已注册路由的描述信息，用于启动时打印路由表、管理页面等场景
+type RouteInfo struct {
+	Method      string   // 请求方法，Mount 注册的不区分请求方法的路由为 *
+	Path        string   // 注册时的完整路由模式，包含分组前缀
+	Host        string   // 路由绑定的 Host 模式，不绑定时为空
+	Version     string   // 路由绑定的 API 版本，不绑定时为空
+	Name        string   // 路由名，未命名时为空
+	Handler     string   // 处理函数的名字，例如 main.getUser
+	Middlewares []string // 实际执行的处理函数链中处理函数之前的全部中间件的名字，按执行顺序排列
+	Group       string   // 路由所属分组的前缀，直接注册在 Engine 上时为空
+}
+
+// Routes 返回全部已注册路由的描述信息，按 Host、路由模式、请求方法、API 版本排序。
+// 函数名通过 runtime 反射获得，匿名函数的名字形如 main.main.func1
+func (engine *Engine) Routes() []RouteInfo {
+	engine.router.mu.RLock()
+	defer engine.router.mu.RUnlock()
+	routes := make([]RouteInfo, 0, len(engine.router.handlers))
+	for _, rt := range engine.router.handlers {
+		info := RouteInfo{
+			Method:  rt.method,
+			Path:    rt.pattern,
+			Host:    rt.host,
+			Version: rt.version,
+			Name:    rt.name,
+			Handler: nameOfFunction(rt.chain[len(rt.chain)-1]),
+		}
+		for _, h := range rt.chain[:len(rt.chain)-1] {
+			info.Middlewares = append(info.Middlewares, nameOfFunction(h))
+		}
+		if rt.group != nil {
+			info.Group = rt.group.prefix
+		}
+		routes = append(routes, info)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Version < b.Version
+	})
+	return routes
+}
+
+// nameOfFunction 通过反射获取函数的完整名字
+func nameOfFunction(f interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+}
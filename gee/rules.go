@@ -0,0 +1,226 @@
+package gee
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 重写与重定向规则
+// 用于迁移旧的 URL 方案，每条规则把匹配 From 的请求路径改写成 To，例如
+//
+//	/blog/:y/:m/:slug -> /posts/:slug  以 301 重定向到新地址
+//	/old/*rest        -> /new/*rest    在服务端内部改写路径，客户端无感知
+//
+// From 使用与路由相同的模式语法（:param、约束、*catchAll 等），To 中可以引用 From 里的参数，
+// 也可以是 https://example.com/posts/:slug 这样带协议和域名的地址（仅用于重定向）。
+// 规则通过 Engine.Pre 在查找路由之前生效：
+//
+//	rules, err := gee.LoadRules("rules.json")
+//	r.Pre(rules.Handler())
+
+// maxRewrites 单个请求最多连续改写的次数，超过时认为规则之间形成了循环
+const maxRewrites = 10
+
+// Rule 一条重写或重定向规则，JSON 格式为 {"from": "/old/*rest", "to": "/new/*rest", "code": 301}
+type Rule struct {
+	From string `json:"from"` // 匹配请求路径的模式
+	To   string `json:"to"`   // 改写后的路径，可以引用 From 中的参数
+	Code int    `json:"code"` // 重定向的状态码，301、302、303、307 或 308；为 0 时在服务端内部改写路径
+}
+
+// Rules 一组重写与重定向规则，From 模式存放在与路由相同的 Radix 树中，匹配优先级也与路由相同，与添加顺序无关。
+// 可以在处理请求的同时添加规则
+type Rules struct {
+	mu        sync.RWMutex
+	root      *node
+	rules     map[string]*Rule // 以 From 模式作为键
+	maxParams int
+}
+
+// NewRules 创建规则集合，rules 中的规则依次通过 Add 添加
+func NewRules(rules ...Rule) *Rules {
+	rs := &Rules{root: &node{}, rules: make(map[string]*Rule)}
+	for _, rule := range rules {
+		rs.Add(rule)
+	}
+	return rs
+}
+
+// LoadRules 从 JSON 文件中加载规则，文件内容是 Rule 的数组。文件无法读取、格式错误或规则不合法时返回错误
+func LoadRules(filename string) (*Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("gee: parse rules %s: %w", filename, err)
+	}
+	rs := NewRules()
+	for _, rule := range rules {
+		if err := rs.tryAdd(rule); err != nil {
+			return nil, fmt.Errorf("gee: load rules %s: %w", filename, err)
+		}
+	}
+	return rs, nil
+}
+
+// Redirect 添加一条以状态码 code 重定向的规则
+func (rs *Rules) Redirect(from string, to string, code int) *Rules {
+	rs.Add(Rule{From: from, To: to, Code: code})
+	return rs
+}
+
+// Rewrite 添加一条在服务端内部改写路径的规则
+func (rs *Rules) Rewrite(from string, to string) *Rules {
+	rs.Add(Rule{From: from, To: to})
+	return rs
+}
+
+// Add 添加一条规则。与注册路由一样，模式不合法、与已有规则冲突、To 引用了 From 中不存在的参数，
+// 或者状态码不是重定向状态码时直接 panic
+func (rs *Rules) Add(rule Rule) {
+	switch rule.Code {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		panic(fmt.Sprintf("gee: invalid redirect code %d in rule '%s'", rule.Code, rule.From))
+	}
+	checkCatchAll(rule.From)
+	path := cleanPattern(rule.From)
+	names := make(map[string]bool)
+	for _, seg := range parseSegments(path) {
+		if seg.kind != staticKind {
+			names[seg.text] = true
+		}
+	}
+	_, target := splitOrigin(rule.To)
+	if rule.Code == 0 && target != rule.To {
+		panic("gee: rewrite rule '" + rule.From + "' must not change the host")
+	}
+	for _, seg := range parseSegments(cleanPattern(target)) {
+		if seg.kind != staticKind && !names[seg.text] && !seg.optional {
+			panic("gee: rule target '" + rule.To + "' uses param '" + seg.text + "' missing in '" + rule.From + "'")
+		}
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, ok := rs.rules[rule.From]; ok {
+		panic("gee: rule '" + rule.From + "' is already registered")
+	}
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				// 与注册路由相同，后面的变体冲突时撤销已经插入的变体再 panic
+				rs.rebuild()
+				panic(err)
+			}
+		}()
+		for _, variant := range expandOptional(path) {
+			rs.root.insert(rule.From, variant, nil)
+		}
+	}()
+	r := rule
+	rs.rules[rule.From] = &r
+	if len(names) > rs.maxParams {
+		rs.maxParams = len(names)
+	}
+}
+
+// rebuild 用 rs.rules 中的规则重建规则树，调用方需要持有写锁
+func (rs *Rules) rebuild() {
+	rs.root = &node{}
+	for from := range rs.rules {
+		for _, variant := range expandOptional(cleanPattern(from)) {
+			rs.root.insert(from, variant, nil)
+		}
+	}
+}
+
+// tryAdd 与 Add 相同，但以错误的形式返回规则不合法的原因
+func (rs *Rules) tryAdd(rule Rule) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+	rs.Add(rule)
+	return nil
+}
+
+// match 查找匹配 path 的规则，返回规则和改写后的路径（已转义），没有匹配时返回 nil
+func (rs *Rules) match(path string) (*Rule, string) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	ps := make(Params, 0, rs.maxParams)
+	n := rs.root.search(cleanPath(path), &ps)
+	if n == nil {
+		return nil, ""
+	}
+	rule := rs.rules[n.pattern]
+	if rule == nil {
+		return nil, ""
+	}
+	origin, target := splitOrigin(rule.To)
+	to, err := buildPath(target, ps.Get)
+	if err != nil {
+		return nil, ""
+	}
+	return rule, origin + to
+}
+
+// Handler 返回应用规则的中间件，需要通过 Engine.Pre 注册，在查找路由之前执行：
+//
+//	重定向规则直接以规则的状态码重定向到改写后的地址，请求中的查询参数原样保留，不再查找路由；
+//	重写规则修改 c.Path 后继续匹配其他规则，最终按改写后的路径查找路由，c.Req.URL 保持原样。
+//
+// 连续改写超过 maxRewrites 次，或者改写、重定向后的路径与原路径相同时，认为规则形成了循环，返回 508 Loop Detected
+func (rs *Rules) Handler() HandlerFunc {
+	return func(c *Context) {
+		for i := 0; ; i++ {
+			rule, to := rs.match(c.Path)
+			if rule == nil {
+				return
+			}
+			if rule.Code != 0 {
+				if to == c.Path || to == c.Req.URL.Path {
+					c.Fail(http.StatusLoopDetected, "gee: redirect loop detected for "+c.Req.URL.Path)
+					return
+				}
+				if c.Req.URL.RawQuery != "" {
+					to += "?" + c.Req.URL.RawQuery
+				}
+				c.Redirect(rule.Code, to)
+				c.index = len(c.handlers)
+				return
+			}
+			if path, err := url.PathUnescape(to); err == nil {
+				to = path
+			}
+			if i == maxRewrites || to == c.Path {
+				c.Fail(http.StatusLoopDetected, "gee: rewrite loop detected for "+c.Req.URL.Path)
+				return
+			}
+			c.Path = to
+		}
+	}
+}
+
+// splitOrigin 把规则的目标地址拆成 协议+域名 和路径两部分，不带协议的地址 origin 为空
+func splitOrigin(to string) (origin string, path string) {
+	i := strings.Index(to, "://")
+	if i < 0 {
+		return "", to
+	}
+	j := strings.IndexByte(to[i+3:], '/')
+	if j < 0 {
+		return to, "/"
+	}
+	return to[:i+3+j], to[i+3+j:]
+}
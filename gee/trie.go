@@ -2,99 +2,411 @@ package gee
 
 import "strings"
 
-//使用 Trie 树实现动态路由(dynamic route)解析
+//使用压缩前缀树(Radix Tree)实现动态路由(dynamic route)解析
+//
+//与按 / 分段的 Trie 树不同，Radix Tree 中的静态节点保存的是若干路由共享的最长公共前缀，
+//例如注册 /hello/:name、/help、/hi 后，树的结构如下：
+//
+//	/h
+//	├── el
+//	│   ├── lo/
+//	│   │   └── :name
+//	│   └── p
+//	└── i
+//
+//查找时直接在原始路径上逐段比较前缀，不需要 strings.Split，也不需要为每一层分配子节点切片；
+//路由参数以子串的形式追加到调用方预先分配好的 Params 中，整个查找过程没有内存分配。
 
-// node Trie树 struct
-// 与普通的树不同，为了实现动态路由匹配，加上了isWild这个参数。即当我们匹配 /p/go/doc/这个路由时，
-// 第一层节点，p精准匹配到了p，第二层节点，go模糊匹配到:lang，那么将会把lang这个参数赋值为go，
-// 继续下一层匹配。我们将匹配的逻辑，包装为一个辅助函数。
+// nodeKind 节点类型
+type nodeKind uint8
+
+const (
+	staticKind   nodeKind = iota // 静态节点，例如 /hello/
+	paramKind                    // 参数节点，例如 :name 或 :id<int>，最多匹配到下一个 / 为止
+	catchAllKind                 // 通配节点，例如 *filepath，匹配剩余的全部路径
+)
+
+// node Radix 树节点 struct
+// 静态子节点按 prefix 的首字节索引在 indices 中，每个静态节点还可以挂若干 :param 子节点和一个 *catchAll 子节点，
+// :param 子节点中带约束的排在前面，不带约束的最多一个且排在最后。
+// 查找时按 静态、:param、*catchAll 的优先级依次尝试，失败时回溯，因此匹配结果与注册顺序无关。
 type node struct {
-	pattern  string  // 待匹配路由，例如 /p/:lang
-	part     string  // 路由中的一部分，例如 :lang
-	children []*node // 子节点，例如 [doc, tutorial, intro]
-	isWild   bool    // 是否精确匹配，part 含有 : 或 * 时为true
+	prefix     string           // 静态节点为压缩后的公共前缀；通配节点为 :name、:name<constraint> 或 *name
+	name       string           // 通配节点的参数名
+	kind       nodeKind         // 节点类型
+	constraint *paramConstraint // :param 节点的约束，没有约束时为 nil
+	indices    string           // 静态子节点 prefix 的首字节，与 children 一一对应
+	children   []*node          // 静态子节点
+	params     []*node          // :param 子节点
+	catchAll   *node            // *catchAll 子节点
+	inline     bool             // :param 节点是否有不以 / 开头的静态子节点，例如 :name.:ext 中的 .
+	pattern    string           // 注册的完整路由，例如 /p/:lang，非空表示此处注册了路由
+	route      *Route           // 路由对应的处理函数链
 }
 
-// matchChild 第一个匹配成功的节点，用于插入
-func (n *node) matchChild(part string) *node {
-	for _, child := range n.children {
-		if child.part == part || child.isWild {
+// segment 路由模式解析后的片段，静态文本或者一个通配符
+type segment struct {
+	kind       nodeKind
+	text       string // 静态片段为原文，通配片段为参数名
+	constraint string // :param 片段 <> 中的约束，没有约束时为空
+	optional   bool   // :param 片段是否以 ? 结尾，表示可以省略
+	raw        string // 片段在路由模式中的原文，例如 :id<int>?
+}
+
+// parseSegments 把路由模式拆成静态片段和通配片段，例如
+//
+//	/hello/:name/*filepath   [/hello/] [:name] [/] [*filepath]
+//	/users/:id<int>          [/users/] [:id 约束 int]
+//	/files/:name.:ext        [/files/] [:name] [.] [:ext]
+//	/archive/:year/:month?   [/archive/] [:year] [/] [:month 可省略]
+//
+// :param 可以出现在一段中的任意位置，参数名由字母、数字和 _ 组成，遇到其他字符就结束，后面可以跟 <约束> 和表示可省略的 ?；
+// 同一段中的两个参数之间必须有静态字符分隔，否则无法确定分界，直接 panic。
+// *catchAll 只能出现在一段的开头，段中间的 * 按普通字符处理
+func parseSegments(pattern string) []segment {
+	var segs []segment
+	start := 0
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != ':' && (c != '*' || i == 0 || pattern[i-1] != '/') {
+			continue
+		}
+		if start < i {
+			segs = append(segs, segment{kind: staticKind, text: pattern[start:i], raw: pattern[start:i]})
+		}
+		seg := segment{kind: paramKind}
+		end := i + 1
+		if c == '*' {
+			seg.kind = catchAllKind
+			for end < len(pattern) && pattern[end] != '/' && pattern[end] != '<' {
+				end++
+			}
+		} else {
+			for end < len(pattern) && isNameByte(pattern[end]) {
+				end++
+			}
+		}
+		seg.text = pattern[i+1 : end]
+		if end < len(pattern) && pattern[end] == '<' {
+			if seg.kind == catchAllKind {
+				panic("gee: catch-all '*" + seg.text + "' in route '" + pattern + "' can not have a constraint")
+			}
+			closing := matchAngle(pattern, end)
+			if closing < 0 {
+				panic("gee: unterminated constraint for ':" + seg.text + "' in route '" + pattern + "'")
+			}
+			seg.constraint = pattern[end+1 : closing]
+			end = closing + 1
+		}
+		if seg.kind == paramKind && end < len(pattern) && pattern[end] == '?' {
+			seg.optional = true
+			end++
+		}
+		if seg.kind == paramKind && end < len(pattern) && pattern[end] == ':' {
+			panic("gee: params '" + pattern[i:end] + "' and the following one in route '" + pattern +
+				"' must be separated by a static part")
+		}
+		seg.raw = pattern[i:end]
+		segs = append(segs, seg)
+		start, i = end, end-1
+	}
+	if start < len(pattern) {
+		segs = append(segs, segment{kind: staticKind, text: pattern[start:], raw: pattern[start:]})
+	}
+	return segs
+}
+
+// isNameByte 判断 c 能否出现在参数名中
+func isNameByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// expandOptional 把带有可省略参数的路由模式展开成所有可能的形式，例如
+// /archive/:year/:month? 展开为 /archive/:year 和 /archive/:year/:month。
+// 可省略参数必须单独占据路由最后的若干段，否则直接 panic；没有可省略参数时原样返回
+func expandOptional(pattern string) []string {
+	segs := parseSegments(pattern)
+	first := -1
+	for i, seg := range segs {
+		if seg.optional {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return []string{pattern}
+	}
+
+	// 第一个可省略参数前面必须是以 / 结尾的静态片段，之后只能是 [/] [:param?] 交替出现
+	if first == 0 || segs[first-1].kind != staticKind || !strings.HasSuffix(segs[first-1].text, "/") {
+		panic("gee: optional param '" + segs[first].raw + "' in route '" + pattern + "' must be a whole segment")
+	}
+	for i := first; i < len(segs); i++ {
+		wantOptional := (i-first)%2 == 0
+		if seg := segs[i]; wantOptional != seg.optional || !wantOptional && seg.text != "/" || i == len(segs)-1 && !wantOptional {
+			panic("gee: optional params in route '" + pattern + "' must be the trailing segments")
+		}
+	}
+
+	var buf strings.Builder
+	for _, seg := range segs[:first] {
+		buf.WriteString(seg.raw)
+	}
+	base := buf.String()
+	if base != "/" {
+		base = strings.TrimSuffix(base, "/")
+	}
+	patterns := []string{base}
+	for i := first; i < len(segs); i += 2 {
+		buf.WriteString(strings.TrimSuffix(segs[i].raw, "?"))
+		patterns = append(patterns, buf.String())
+		buf.WriteString("/")
+	}
+	return patterns
+}
+
+// matchAngle 返回与 pattern[open] 处的 < 配对的 > 的位置，正则表达式中用 \ 转义的字符和嵌套的 <> 会被跳过，找不到时返回 -1
+func matchAngle(pattern string, open int) int {
+	depth := 0
+	for i := open; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '<':
+			depth++
+		case '>':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// longestCommonPrefix 返回 a 与 b 最长公共前缀的长度
+func longestCommonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// addStatic 从节点 n 开始插入静态片段 s，返回 s 结束位置所在的节点。
+// 如果 s 只和某个已有子节点的 prefix 部分相同，就在公共前缀处把这个子节点分裂成两层
+func (n *node) addStatic(s string) *node {
+	for len(s) > 0 {
+		i := strings.IndexByte(n.indices, s[0])
+		if i < 0 {
+			child := &node{prefix: s, kind: staticKind}
+			n.indices += string(s[0])
+			n.children = append(n.children, child)
+			if n.kind == paramKind && s[0] != '/' {
+				n.inline = true
+			}
 			return child
 		}
+		child := n.children[i]
+		l := longestCommonPrefix(s, child.prefix)
+		if l < len(child.prefix) {
+			// 分裂：child 保留公共前缀，原来的后半部分下沉为它唯一的子节点
+			tail := *child
+			tail.prefix = child.prefix[l:]
+			*child = node{
+				prefix:   child.prefix[:l],
+				kind:     staticKind,
+				indices:  string(tail.prefix[0]),
+				children: []*node{&tail},
+			}
+		}
+		s = s[l:]
+		n = child
 	}
-	return nil
+	return n
 }
 
-// matchChildren 所有匹配成功的节点，用于查找
-func (n *node) matchChildren(part string) []*node {
-	nodes := make([]*node, 0)
-	for _, child := range n.children {
-		if child.part == part || child.isWild {
-			nodes = append(nodes, child)
+// addWild 在节点 n 下插入通配片段 seg，返回对应的通配节点。
+// 同一位置只允许出现一个 *catchAll；:param 按约束区分，约束相同（包括都没有约束）但名字不同的参数无法区分，视为冲突
+func (n *node) addWild(pattern string, seg segment) *node {
+	if seg.kind == catchAllKind {
+		if n.catchAll == nil {
+			n.catchAll = &node{prefix: "*" + seg.text, name: seg.text, kind: catchAllKind}
+		} else if n.catchAll.name != seg.text {
+			panic("gee: wildcard '*" + seg.text + "' in route '" + pattern +
+				"' conflicts with existing wildcard '" + n.catchAll.prefix + "' at the same level")
 		}
+		return n.catchAll
 	}
-	return nodes
+
+	if seg.text == "" {
+		panic("gee: wildcard ':' in route '" + pattern + "' must be named")
+	}
+	prefix := ":" + seg.text
+	if seg.constraint != "" {
+		prefix += "<" + seg.constraint + ">"
+	}
+	for _, child := range n.params {
+		if child.constraintText() != seg.constraint {
+			continue
+		}
+		if child.name != seg.text {
+			panic("gee: wildcard '" + prefix + "' in route '" + pattern +
+				"' conflicts with existing wildcard '" + child.prefix + "' at the same level")
+		}
+		return child
+	}
+
+	child := &node{prefix: prefix, name: seg.text, kind: paramKind}
+	if seg.constraint != "" {
+		child.constraint = getConstraint(seg.constraint)
+	}
+	// 带约束的参数节点排在不带约束的参数节点之前
+	i := len(n.params)
+	if i > 0 && child.constraint != nil && n.params[i-1].constraint == nil {
+		i--
+	}
+	n.params = append(n.params, nil)
+	copy(n.params[i+1:], n.params[i:])
+	n.params[i] = child
+	return child
 }
 
-//Trie 树需要支持节点的插入与查询。插入功能很简单，递归查找每一层的节点，
-//如果没有匹配到当前part的节点，则新建一个，有一点需要注意，/p/:lang/doc只有在第三层节点，
-//即doc节点，pattern才会设置为/p/:lang/doc。p和:lang节点的pattern属性皆为空。
-//因此，当匹配结束时，我们可以使用n.pattern == ""来判断路由规则是否匹配成功。
-//例如，/p/python虽能成功匹配到:lang，但:lang的pattern值为空，
-//因此匹配失败。查询功能，同样也是递归查询每一层的节点，退出规则是，匹配到了*，匹配失败，或者匹配到了第len(parts)层节点。
+// constraintText 返回 :param 节点约束的原文，没有约束时返回空字符串
+func (n *node) constraintText() string {
+	if n.constraint == nil {
+		return ""
+	}
+	return n.constraint.text
+}
 
-// insert 这是一个使用递归方式实现的Trie树节点的插入方法，此方法用于将给定的模式字符串 pattern 插入到Trie树的节点 n 中。它接收三个参数：
-//
-//	pattern  是要插入的模式字符串。
-//	parts    是 pattern 字符串按照 / 分割后的字符串切片。
-//	height   是当前 Trie 树节点的高度，即该节点在 Trie 树中的深度。
-//
-// 如果字符串切片 parts 的长度等于路由树的高度 height，则表示已经到达了路由模式的末尾，可以将路由模式添加到当前节点中。
-// 否则，从当前节点的子节点中查找与当前部分 part 匹配的节点，如果找到了匹配的子节点，则将其作为当前节点，递归调用 insert() 方法，
-// 将剩余的部分添加到匹配的子节点中。如果没有找到匹配的子节点，则创建一个新的节点，将其作为当前节点的子节点，
-// 将当前部分 part 添加到新节点中，然后递归调用 insert() 方法，将剩余的部分添加到新节点中。
-func (n *node) insert(pattern string, parts []string, height int) {
-	if len(parts) == height {
-		n.pattern = pattern
-		return
-	}
-
-	part := parts[height]
-	child := n.matchChild(part)
-	if child == nil {
-		child = &node{part: part, isWild: part[0] == ':' || part[0] == '*'}
-		n.children = append(n.children, child)
-	}
-	child.insert(pattern, parts, height+1)
+// matchParam 判断路由段 value 能否匹配 :param 节点 n
+func (n *node) matchParam(value string) bool {
+	return value != "" && (n.constraint == nil || n.constraint.match(value))
 }
 
-// search 用于在路由树中查找匹配给定路由部分的节点。
-// 该方法接收两个参数：
-//
-//	parts   表示待匹配的路由部分，
-//	height  表示当前节点在路由树中的高度。
-//
-// 在此方法中，首先判断字符串切片 parts 的长度是否等于路由树的高度 height，或者当前节点的 part 字段以 * 开头，如果是，
-// 则表示已经匹配到了路由的末尾或通配符节点，此时判断当前节点是否包含路由模式，如果包含，则返回当前节点，否则返回 nil。
-// 如果还没有匹配到路由的末尾，就从当前节点的子节点中查找与当前部分 part 匹配的节点，并递归递归调用 search() 方法，
-// 匹配剩余的路由部分。如果找到了匹配的子节点，则将其作为当前节点，继续递归查找，直到匹配到路由的末尾或没有匹配的子节点。
-// 如果没有匹配的子节点，则返回 nil。
-func (n *node) search(parts []string, height int) *node {
-	if len(parts) == height || strings.HasPrefix(n.part, "*") {
+// prevEnd 返回 :param 节点 n 在 path 中小于 end 的下一个候选结束位置，没有时返回 0。
+// 参数默认匹配到下一个 / 为止；n 有 . 一类的内联静态子节点时，参数也可以在这些字符前结束，
+// 候选位置从右往左尝试，因此 /files/:name.:ext 匹配 /files/a.b.c 时 name 为 a.b、ext 为 c
+func (n *node) prevEnd(path string, end int) int {
+	if !n.inline {
+		return 0
+	}
+	for end--; end > 0; end-- {
+		if c := path[end]; c != '/' && strings.IndexByte(n.indices, c) >= 0 {
+			return end
+		}
+	}
+	return 0
+}
+
+// insert 把路由模式 pattern 插入到以 n 为根的树中，path 是规范化之后的路由模式。
+// 依次插入每个片段，最后在片段结束的节点上记录 pattern 与 route；
+// 该节点已经注册过路由、同一位置出现了名字不同的同类通配符，或者同一个参数名出现了不止一次，都会直接 panic。
+func (n *node) insert(pattern string, path string, rt *Route) *node {
+	var names []string
+	for _, seg := range parseSegments(path) {
+		if seg.kind == staticKind {
+			n = n.addStatic(seg.text)
+			continue
+		}
+		for _, name := range names {
+			if seg.text != "" && name == seg.text {
+				panic("gee: param '" + seg.text + "' appears more than once in route '" + pattern + "'")
+			}
+		}
+		names = append(names, seg.text)
+		n = n.addWild(pattern, seg)
+	}
+	if n.pattern != "" {
+		panic("gee: route '" + pattern + "' conflicts with existing route '" + n.pattern + "'")
+	}
+	n.pattern = pattern
+	n.route = rt
+	return n
+}
+
+// searchFold 不区分大小写地在 n 的子树中查找剩余路径 path，用于 RedirectFixedPath。
+// 匹配成功时返回 buf 加上按注册路由的大小写拼出的剩余路径，参数部分保留请求中的原值。
+// 只在没有精确匹配时调用，因此不追求零分配
+func (n *node) searchFold(path string, buf []byte) ([]byte, bool) {
+	if path == "" {
+		return buf, n.pattern != ""
+	}
+
+	for _, child := range n.children {
+		if len(path) >= len(child.prefix) && strings.EqualFold(path[:len(child.prefix)], child.prefix) {
+			if out, ok := child.searchFold(path[len(child.prefix):], append(buf, child.prefix...)); ok {
+				return out, true
+			}
+		}
+	}
+
+	segEnd := strings.IndexByte(path, '/')
+	if segEnd < 0 {
+		segEnd = len(path)
+	}
+	for _, child := range n.params {
+		for end := segEnd; end > 0; end = child.prevEnd(path, end) {
+			if child.matchParam(path[:end]) {
+				if out, ok := child.searchFold(path[end:], append(buf, path[:end]...)); ok {
+					return out, true
+				}
+			}
+		}
+	}
+
+	if child := n.catchAll; child != nil && child.pattern != "" {
+		return append(buf, path...), true
+	}
+	return nil, false
+}
+
+// search 在 n 的子树中查找剩余路径 path，n 自身的部分已经匹配完毕。
+// 匹配到的参数以 path 子串的形式追加到 ps 中，调用方预留足够的容量时整个查找没有内存分配。
+// 子节点按 静态、:param、*catchAll 的优先级依次尝试，某个分支匹配失败时撤销该分支追加的参数并回溯到下一个候选，
+// 不满足约束的 :param 节点直接跳过。
+func (n *node) search(path string, ps *Params) *node {
+	if path == "" {
 		if n.pattern == "" {
 			return nil
 		}
 		return n
 	}
 
-	part := parts[height]
-	children := n.matchChildren(part)
+	if i := strings.IndexByte(n.indices, path[0]); i >= 0 {
+		child := n.children[i]
+		if strings.HasPrefix(path, child.prefix) {
+			if result := child.search(path[len(child.prefix):], ps); result != nil {
+				return result
+			}
+		}
+	}
+
+	if len(n.params) > 0 {
+		segEnd := strings.IndexByte(path, '/')
+		if segEnd < 0 {
+			segEnd = len(path)
+		}
+		for _, child := range n.params {
+			for end := segEnd; end > 0; end = child.prevEnd(path, end) {
+				if !child.matchParam(path[:end]) {
+					continue
+				}
+				*ps = append(*ps, Param{Key: child.name, Value: path[:end]})
+				if result := child.search(path[end:], ps); result != nil {
+					return result
+				}
+				*ps = (*ps)[:len(*ps)-1]
+			}
+		}
+	}
 
-	for _, child := range children {
-		result := child.search(parts, height+1)
-		if result != nil {
-			return result
+	if child := n.catchAll; child != nil && child.pattern != "" {
+		if child.name != "" {
+			*ps = append(*ps, Param{Key: child.name, Value: path})
 		}
+		return child
 	}
 	return nil
 }
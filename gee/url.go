@@ -0,0 +1,80 @@
+package gee
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URL 按路由名 name 反向生成 URL，params 依次是 参数名、参数值 成对出现，例如
+//
+//	r.GET("/users/:id/files/*filepath", handler).Name("user-file")
+//	r.URL("user-file", "id", 42, "filepath", "docs/a b.txt") // /users/42/files/docs/a%20b.txt
+//
+// :param 的值整体做路径转义，其中的 / 也会被转义；*catchAll 的值按 / 分段后逐段转义；
+// 没有提供可省略参数的值时，生成省略该参数的 URL。
+// 路由名不存在、参数不成对、缺少路由需要的参数或者参数不满足约束时返回错误
+func (engine *Engine) URL(name string, params ...interface{}) (string, error) {
+	engine.router.mu.RLock()
+	rt, ok := engine.router.names[name]
+	engine.router.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("gee: no route named %q", name)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("gee: URL params for route %q must be key-value pairs", name)
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		key, ok := params[i].(string)
+		if !ok {
+			return "", fmt.Errorf("gee: URL param name %v for route %q is not a string", params[i], name)
+		}
+		values[key] = fmt.Sprint(params[i+1])
+	}
+	return buildPath(rt.pattern, func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	})
+}
+
+// buildPath 用 lookup 提供的参数值替换路由模式 pattern 中的通配符，生成转义后的路径。
+// 匿名的 * 通配符使用 "*" 作为参数名
+func buildPath(pattern string, lookup func(key string) (string, bool)) (string, error) {
+	var buf strings.Builder
+	for _, seg := range parseSegments(cleanPattern(pattern)) {
+		if seg.kind == staticKind {
+			buf.WriteString(seg.text)
+			continue
+		}
+		key := seg.text
+		if key == "" {
+			key = "*"
+		}
+		value, ok := lookup(key)
+		if (!ok || value == "") && seg.optional {
+			// 可省略参数只会出现在末尾，省略时连同前面的 / 一起去掉
+			path := strings.TrimSuffix(buf.String(), "/")
+			if path == "" {
+				path = "/"
+			}
+			return path, nil
+		}
+		if !ok || value == "" {
+			return "", fmt.Errorf("gee: missing param %q for route %q", key, pattern)
+		}
+		if seg.kind == paramKind {
+			if seg.constraint != "" && !getConstraint(seg.constraint).match(value) {
+				return "", fmt.Errorf("gee: param %q=%q does not satisfy constraint <%s> of route %q", key, value, seg.constraint, pattern)
+			}
+			buf.WriteString(url.PathEscape(value))
+			continue
+		}
+		parts := strings.Split(value, "/")
+		for i, part := range parts {
+			parts[i] = url.PathEscape(part)
+		}
+		buf.WriteString(strings.Join(parts, "/"))
+	}
+	return buf.String(), nil
+}
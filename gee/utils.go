@@ -2,6 +2,7 @@ package gee
 
 import (
 	"log"
+	"path"
 	"strings"
 	"sync"
 )
@@ -30,6 +31,49 @@ func Concat(s ...string) string {
 	return buf.String()
 }
 
+// validMethod 判断 method 是否为合法的 HTTP 方法名。
+// RFC 7230 规定方法名是一个 token，只能由字母、数字以及 !#$%&'*+-.^_`|~ 组成，且不能为空
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for i := 0; i < len(method); i++ {
+		c := method[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// cleanPath 规范化请求路径：补上开头的 /，合并多余的 /，处理 . 和 ..，但保留末尾的 /。
+// 常见的请求路径本身就是规范的，这时直接返回 p，不会分配内存
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] == '/' && !strings.Contains(p, "//") && !strings.Contains(p, "/./") &&
+		!strings.Contains(p, "/../") && !strings.HasSuffix(p, "/.") && !strings.HasSuffix(p, "/..") {
+		return p
+	}
+	cleaned := path.Clean("/" + p)
+	if cleaned != "/" && p[len(p)-1] == '/' {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// toggleTrailingSlash 去掉或者补上 p 末尾的 /
+func toggleTrailingSlash(p string) string {
+	if strings.HasSuffix(p, "/") {
+		return p[:len(p)-1]
+	}
+	return p + "/"
+}
+
 // Debug 用于检查错误
 const Debug = false
 
@@ -0,0 +1,128 @@
+package gee
+
+import (
+	"net/http"
+	"strings"
+)
+
+// 基于请求头的 API 版本路由
+// 通过 RouterGroup.Version 创建的分组绑定到一个 API 版本，同一请求方法和路由模式可以为不同的版本各注册一次，
+// 不需要在 URL 中加上 /v1、/v2 这样的前缀：
+//
+//	v1 := r.Version("1")
+//	v1.GET("/users/:id", getUserV1)
+//	v2 := r.Version("2")
+//	v2.GET("/users/:id", getUserV2)
+//
+// 请求的版本依次从以下请求头中读取：
+//
+//	X-API-Version: 2
+//	Accept: application/vnd.acme.v2+json
+//
+// 版本号开头的 v 会被忽略，因此 Version("v2") 与 Version("2") 相同。选择路由时依次尝试
+// 请求的版本、Engine.DefaultVersion、不绑定版本的路由，都没有时按 404 处理。
+
+// Version 返回一个绑定到 API 版本 version 的分组，分组的前缀、Host 和中间件都与当前分组相同，
+// 在它下面创建的子分组同样绑定到这个版本
+func (group *RouterGroup) Version(version string) *RouterGroup {
+	version = normalizeVersion(version)
+	if version == "" {
+		panic("gee: API version must not be empty")
+	}
+	engine := group.engine
+	newGroup := &RouterGroup{
+		prefix:  group.prefix,
+		host:    group.host,
+		version: version,
+		parent:  group,
+		engine:  engine,
+	}
+	engine.router.mu.Lock()
+	engine.groups = append(engine.groups, newGroup)
+	engine.router.mu.Unlock()
+	return newGroup
+}
+
+// selectVersion 在 rt 的各个版本中选出处理请求 c 的路由，没有合适的版本时返回 nil。
+// 路由模式只注册了一个不绑定版本的路由时直接返回 rt，不需要解析请求头
+func (rt *Route) selectVersion(c *Context) *Route {
+	if rt.variants == nil && rt.version == "" {
+		return rt
+	}
+	if rt.variants != nil {
+		// 响应内容取决于版本请求头，需要告诉缓存
+		c.Writer.Header().Add("Vary", "Accept, X-API-Version")
+	}
+	return rt.variant(requestVersion(c.Req), c.engine.DefaultVersion)
+}
+
+// variant 按 请求的版本 version、默认版本 def、不绑定版本 的顺序选出路由
+func (rt *Route) variant(version string, def string) *Route {
+	variants := rt.variants
+	if variants == nil {
+		variants = []*Route{rt}
+	}
+	for _, want := range []string{version, normalizeVersion(def)} {
+		if want == "" {
+			continue
+		}
+		for _, v := range variants {
+			if v.version == want {
+				return v
+			}
+		}
+	}
+	for _, v := range variants {
+		if v.version == "" {
+			return v
+		}
+	}
+	return nil
+}
+
+// requestVersion 从请求头中读取请求的 API 版本，没有指定时返回空字符串
+func requestVersion(req *http.Request) string {
+	if v := req.Header.Get("X-API-Version"); v != "" {
+		return normalizeVersion(v)
+	}
+	for _, accept := range req.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+				mediaType = mediaType[:i]
+			}
+			// application/vnd.acme.v2+json
+			mediaType = strings.TrimSpace(mediaType)
+			i := strings.Index(mediaType, "/vnd.")
+			if i < 0 {
+				continue
+			}
+			subtype := mediaType[i+len("/vnd."):]
+			if j := strings.IndexByte(subtype, '+'); j >= 0 {
+				subtype = subtype[:j]
+			}
+			if j := strings.LastIndex(subtype, ".v"); j >= 0 {
+				if v := normalizeVersion(subtype[j+1:]); v != "" {
+					return v
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// normalizeVersion 去掉版本号两端的空白和开头的 v
+func normalizeVersion(version string) string {
+	version = strings.TrimSpace(version)
+	if len(version) > 1 && (version[0] == 'v' || version[0] == 'V') {
+		version = version[1:]
+	}
+	return version
+}
+
+// versionSuffix 返回日志和错误信息中附加在路由后面的版本说明，不绑定版本时为空
+func versionSuffix(version string) string {
+	if version == "" {
+		return ""
+	}
+	return " (version " + version + ")"
+}